@@ -0,0 +1,84 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the package documentation and agent command implementation.
+
+// Package agent contains the agent command.
+// When executed, it keeps running and owns the store. It tracks the events track sends to it over a Unix socket,
+// and flushes them periodically, so that the hooks don't initialize the store and send the events themselves.
+package agent
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/getoutreach/gobox/pkg/trace"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	"github.com/getoutreach/devtel/internal/ingest"
+	"github.com/getoutreach/devtel/internal/pipeline"
+)
+
+// NewCommand returns a new agent command.
+func NewCommand(teleforkAPIKey string) *cli.Command {
+	return &cli.Command{
+		Name:  "agent",
+		Usage: "Run the agent the events are tracked and sent by",
+		Flags: append(pipeline.Flags(),
+			&cli.DurationFlag{
+				Name:    "flush-interval",
+				Usage:   "How often the tracked events are sent",
+				Value:   30 * time.Second,
+				EnvVars: []string{"DEVTEL_AGENT_FLUSH_INTERVAL"},
+			},
+		),
+		Action: func(c *cli.Context) error {
+			ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			p, err := pipeline.New(c, teleforkAPIKey)
+			if err != nil {
+				return err
+			}
+			//nolint:errcheck // Why: The events are flushed before the agent stops.
+			defer p.Close()
+
+			unlock, ok, err := p.LockAgent()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errors.New("another agent is running")
+			}
+			defer unlock()
+
+			if err := p.Store.Init(ctx); err != nil {
+				return err
+			}
+
+			// The retention is enforced when the agent starts, the hooks leave it to the agent.
+			if err := p.GC(ctx); err != nil {
+				//nolint:errcheck // Why: The store is still usable, the retention is enforced next time.
+				trace.SetCallStatus(ctx, err)
+			}
+
+			opts := ingest.Options{
+				FlushInterval: c.Duration("flush-interval"),
+				FlushPolicy:   p.FlushPolicy,
+				FlushTimeout:  p.FlushTimeout,
+			}
+			if p.Persistent() {
+				opts.LockFlush = p.LockFlush
+			}
+
+			l, err := ingest.Listen(p.AgentSocket)
+			if err != nil {
+				return err
+			}
+
+			return ingest.NewServer(p.Store, p.Processor, opts).Serve(ctx, l)
+		},
+	}
+}
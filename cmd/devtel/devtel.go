@@ -16,6 +16,9 @@ import (
 
 	// Place any extra imports for your startup code here
 	// <<Stencil::Block(imports)>>
+	"github.com/getoutreach/devtel/cmd/devtel/agent"
+	"github.com/getoutreach/devtel/cmd/devtel/flush"
+	"github.com/getoutreach/devtel/cmd/devtel/gc"
 	"github.com/getoutreach/devtel/cmd/devtel/track"
 	// <</Stencil::Block>>
 )
@@ -63,6 +66,9 @@ func main() {
 	app.Commands = []*cli.Command{
 		// <<Stencil::Block(commands)>>
 		track.NewCommand(TeleforkAPIKey),
+		gc.NewCommand(),
+		flush.NewCommand(TeleforkAPIKey),
+		agent.NewCommand(TeleforkAPIKey),
 		// <</Stencil::Block>>
 	}
 
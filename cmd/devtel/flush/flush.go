@@ -0,0 +1,63 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the package documentation and flush command implementation.
+
+// Package flush contains the flush command.
+// When executed, it sends the tracked events that were not sent yet. It's started in the background by track,
+// only one flush runs at a time, the others exit right away. It enforces the retention policy of the store as well,
+// so that the hooks don't.
+package flush
+
+import (
+	"github.com/getoutreach/gobox/pkg/trace"
+	"github.com/urfave/cli/v2"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+	"github.com/getoutreach/devtel/internal/pipeline"
+)
+
+// NewCommand returns a new flush command.
+func NewCommand(teleforkAPIKey string) *cli.Command {
+	return &cli.Command{
+		Name:  "flush",
+		Usage: "Send the tracked events",
+		Flags: pipeline.Flags(),
+		Action: func(c *cli.Context) error {
+			p, err := pipeline.New(c, teleforkAPIKey)
+			if err != nil {
+				return err
+			}
+			//nolint:errcheck // Why: The events are already marked.
+			defer p.Close()
+
+			if p.Persistent() {
+				unlock, ok, err := p.LockFlush()
+				if err != nil {
+					return err
+				}
+				if !ok {
+					// Another process is flushing, the events it misses are sent by the next flush.
+					return nil
+				}
+				defer unlock()
+			}
+
+			if err := p.Store.Init(c.Context); err != nil {
+				return err
+			}
+
+			ctx, cancel := p.FlushContext(c.Context)
+			defer cancel()
+
+			err = devspace.NewTracker(p.Processor, p.Store).Flush(ctx)
+
+			if gerr := p.GC(c.Context); gerr != nil {
+				// The retention is enforced by the next flush.
+				//nolint:errcheck // Why: The events are already sent.
+				trace.SetCallStatus(c.Context, gerr)
+			}
+
+			return err
+		},
+	}
+}
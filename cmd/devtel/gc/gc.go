@@ -0,0 +1,93 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the package documentation and gc command implementation.
+
+// Package gc contains the gc command.
+// When executed, it removes the telemetry log files (or the SQLite entries) over the retention policy and reports them.
+package gc
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/getoutreach/devtel/internal/pipeline"
+	"github.com/getoutreach/devtel/internal/store"
+)
+
+// NewCommand returns a new gc command.
+func NewCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "gc",
+		Usage: "Remove expired telemetry logs",
+		Flags: append(pipeline.StoreFlags(),
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only report the logs that would be removed",
+			},
+			&cli.DurationFlag{
+				Name:  "max-age",
+				Usage: "Remove logs not modified for longer than this, defaults to the store retention",
+			},
+			&cli.Int64Flag{
+				Name:  "max-size",
+				Usage: "Remove the oldest logs while the logs are over this many bytes, defaults to the store retention",
+			},
+			&cli.StringFlag{
+				Name:   "log-dir",
+				Usage:  "Directory with the telemetry logs",
+				Hidden: true,
+			},
+		),
+		Action: func(c *cli.Context) error {
+			s, err := pipeline.NewStore(c, &store.Options{
+				LogDir:            c.String("log-dir"),
+				RetentionMaxAge:   c.Duration("max-age"),
+				RetentionMaxBytes: c.Int64("max-size"),
+			})
+			if err != nil {
+				return err
+			}
+			if closer, ok := s.(io.Closer); ok {
+				//nolint:errcheck // Why: The store is only collected.
+				defer closer.Close()
+			}
+
+			collector, ok := s.(store.Collector)
+			if !ok {
+				return fmt.Errorf("the %s store has no retention policy", c.String("store"))
+			}
+
+			if err := s.Init(c.Context); err != nil {
+				return err
+			}
+
+			dryRun := c.Bool("dry-run")
+			segments, err := collector.GC(c.Context, dryRun)
+			if err != nil {
+				return err
+			}
+
+			verb := "Removed"
+			if dryRun {
+				verb = "Would remove"
+			}
+
+			var size int64
+			for _, seg := range segments {
+				size += seg.Size
+				fmt.Fprintf(c.App.Writer, "%s %s (%d bytes, modified %s)\n",
+					verb, seg.Name, seg.Size, seg.ModTime.Format(time.RFC3339))
+			}
+			unit := "log files"
+			if _, ok := s.(*store.SQLiteStore); ok {
+				unit = "entries"
+			}
+			fmt.Fprintf(c.App.Writer, "%s %d %s, %d bytes\n", verb, len(segments), unit, size)
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package gc_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+
+	"github.com/getoutreach/devtel/cmd/devtel/gc"
+)
+
+func TestGC(t *testing.T) {
+	dir := t.TempDir()
+	expired := filepath.Join(dir, "1651388142.log")
+	current := filepath.Join(dir, "1651388143.log")
+
+	assert.NoError(t, os.WriteFile(expired, []byte(`{"key":"a","data":{"id":"a"}}`+"\n"), 0o600))
+	assert.NoError(t, os.WriteFile(current, []byte(`{"key":"b","data":{"id":"b"}}`+"\n"), 0o600))
+	old := time.Now().Add(-48 * time.Hour)
+	assert.NoError(t, os.Chtimes(expired, old, old))
+
+	var out bytes.Buffer
+	app := &cli.App{
+		Name:     "devtel",
+		Writer:   &out,
+		Commands: []*cli.Command{gc.NewCommand()},
+	}
+
+	assert.NoError(t, app.Run([]string{"devtel", "gc", "--dry-run", "--max-age", "24h", "--log-dir", dir}))
+	assert.Contains(t, out.String(), "Would remove 1651388142.log (30 bytes")
+	assert.Contains(t, out.String(), "Would remove 1 log files, 30 bytes")
+	assert.FileExists(t, expired)
+
+	out.Reset()
+	assert.NoError(t, app.Run([]string{"devtel", "gc", "--max-age", "24h", "--log-dir", dir}))
+	assert.Contains(t, out.String(), "Removed 1 log files, 30 bytes")
+	assert.NoFileExists(t, expired)
+	assert.FileExists(t, current)
+}
+
+func TestGCRejectsStoresWithoutRetention(t *testing.T) {
+	app := &cli.App{
+		Name:     "devtel",
+		Writer:   io.Discard,
+		Commands: []*cli.Command{gc.NewCommand()},
+	}
+
+	err := app.Run([]string{"devtel", "gc", "--store", "memory"})
+	assert.EqualError(t, err, "the memory store has no retention policy")
+}
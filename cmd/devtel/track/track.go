@@ -3,7 +3,10 @@
 // Description: This file contains the package documentation and track command implementation.
 
 // Package track contains the track command.
-// When executed, it will try to match current hook call with a matching before: hook, add duration, and send it to Telefork.
+// When executed, it hands the event off to the devtel agent when it's running. Otherwise it will try to match current
+// hook call with a matching before: hook, add duration, and once the flush policy is met, hand the events off to
+// a detached flush process that sends them to Telefork.
+// With --dry-run, it prints the queued events to stderr instead, and leaves them queued for the real sink.
 package track
 
 import (
@@ -15,9 +18,10 @@ import (
 
 	"github.com/urfave/cli/v2"
 
+	"github.com/getoutreach/devtel/internal/console"
 	"github.com/getoutreach/devtel/internal/devspace"
-	"github.com/getoutreach/devtel/internal/store"
-	"github.com/getoutreach/devtel/internal/telefork"
+	"github.com/getoutreach/devtel/internal/ingest"
+	"github.com/getoutreach/devtel/internal/pipeline"
 	"github.com/getoutreach/gobox/pkg/trace"
 )
 
@@ -57,27 +61,112 @@ func NewCommand(teleforkAPIKey string) *cli.Command {
 	return &cli.Command{
 		Name:  "track",
 		Usage: "Track events",
+		Flags: append(pipeline.Flags(),
+			&cli.BoolFlag{
+				Name:    "background-flush",
+				Usage:   "Send the events from a detached process, so that the hook doesn't wait on it",
+				Value:   true,
+				EnvVars: []string{"DEVTEL_BACKGROUND_FLUSH"},
+			},
+			&cli.BoolFlag{
+				Name:    "dry-run",
+				Usage:   "Print the queued events to stderr instead of sending them, they stay queued for the sink",
+				EnvVars: []string{"DEVTEL_DRY_RUN"},
+			},
+			&cli.StringFlag{
+				Name:    "dry-run-format",
+				Usage:   "Format the events are printed in with --dry-run: table or json",
+				Value:   string(console.FormatTable),
+				EnvVars: []string{"DEVTEL_DRY_RUN_FORMAT"},
+			},
+		),
 		Action: func(c *cli.Context) error {
-			s := store.New(&store.Options{})
-			p := telefork.NewProcessor(c.App.Name, teleforkAPIKey)
-			if err := s.Init(c.Context); err != nil {
+			p, err := pipeline.New(c, teleforkAPIKey)
+			if err != nil {
 				//nolint:errcheck // Why: We don't wat to crash devspace because of telemetry errors.
 				trace.SetCallStatus(c.Context, err)
 				return nil
 			}
-			t := devspace.NewTracker(p, s)
+			//nolint:errcheck // Why: The events are already written.
+			defer p.Close()
 
 			props := commonProps()
-			for k, v := range props {
-				s.AddDefaultField(k, v)
+			event := devspace.EventFromEnv()
+
+			if c.Bool("dry-run") {
+				return dryRun(c, p, event, props)
 			}
 
-			defer t.Flush(c.Context)
+			// The agent owns the store when it's running, it matches and sends the event.
+			if err := ingest.Send(c.Context, p.AgentSocket, event, props); err == nil {
+				return nil
+			}
+
+			if err := p.Store.Init(c.Context); err != nil {
+				//nolint:errcheck // Why: We don't wat to crash devspace because of telemetry errors.
+				trace.SetCallStatus(c.Context, err)
+				return nil
+			}
+			t := devspace.NewTracker(p.Processor, p.Store, devspace.WithFlushPolicy(p.FlushPolicy))
+
+			for k, v := range props {
+				p.Store.AddDefaultField(k, v)
+			}
 
-			event := devspace.EventFromEnv()
 			t.Track(c.Context, event)
+			if !t.ShouldFlush(c.Context, event) {
+				return nil
+			}
+
+			// The events kept in memory can't be flushed by another process.
+			if c.Bool("background-flush") && p.Persistent() {
+				err := p.SpawnFlush()
+				if err == nil {
+					return nil
+				}
+				//nolint:errcheck // Why: We flush right away instead.
+				trace.SetCallStatus(c.Context, err)
+			}
+
+			if p.Persistent() {
+				unlock, ok, err := p.LockFlush()
+				if err != nil || !ok {
+					// Another process is flushing, the events it misses are sent by the next flush.
+					//nolint:errcheck // Why: We don't wat to crash devspace because of telemetry errors.
+					trace.SetCallStatus(c.Context, err)
+					return nil
+				}
+				defer unlock()
+			}
+
+			ctx, cancel := p.FlushContext(c.Context)
+			defer cancel()
+
+			//nolint:errcheck // Why: The events are sent by the next flush.
+			t.Flush(ctx)
 
 			return nil
 		},
 	}
 }
+
+// dryRun tracks the event, and prints all the queued events without marking them, so that they're still sent
+// by the next flush. The agent is bypassed, as it would send the events.
+func dryRun(c *cli.Context, p *pipeline.Pipeline, event *devspace.Event, props map[string]interface{}) error {
+	format, err := console.ParseFormat(c.String("dry-run-format"))
+	if err != nil {
+		return err
+	}
+
+	if err := p.Store.Init(c.Context); err != nil {
+		return err
+	}
+	for k, v := range props {
+		p.Store.AddDefaultField(k, v)
+	}
+
+	t := devspace.NewTracker(console.NewProcessor(c.App.ErrWriter, format), p.Store, devspace.WithDryRun())
+	t.Track(c.Context, event)
+
+	return t.Flush(c.Context)
+}
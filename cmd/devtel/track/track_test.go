@@ -3,10 +3,12 @@
 package track_test
 
 import (
+	"bytes"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/getoutreach/gobox/pkg/log"
@@ -42,6 +44,8 @@ func TestTrackEvent(t *testing.T) {
 
 	os.Setenv("OUTREACH_TELEFORK_ENDPOINT", server.URL)
 	os.Setenv("DEV_EMAIL", "yoda@outreach.io")
+	// No agent listens on the socket, the command tracks the event itself.
+	os.Setenv("DEVTEL_AGENT_SOCKET", filepath.Join(t.TempDir(), ".agent.sock"))
 
 	os.Setenv("DEVSPACE_PLUGIN_EVENT", "before:build")
 	os.Setenv("DEVSPACE_PLUGIN_EXECUTION_ID", "031cb474-c2f4-433f-863e-684c35c8d5ac")
@@ -51,7 +55,9 @@ func TestTrackEvent(t *testing.T) {
 	os.Setenv("DEVSPACE_PLUGIN_COMMAND_ARGS", "")
 	os.Setenv("DEVSPACE_PLUGIN_ERROR", "")
 
-	os.Args = []string{"devtel", "track"}
+	// The events are flushed by the command itself, so that the request is made before the test ends.
+	// Every event meets the flush policy.
+	os.Args = []string{"devtel", "track", "--background-flush=false", "--flush-max-events=1"}
 	app := &cli.App{
 		Name: "devtel",
 		Commands: []*cli.Command{
@@ -61,3 +67,38 @@ func TestTrackEvent(t *testing.T) {
 
 	app.Run(os.Args)
 }
+
+func TestTrackEventDryRun(t *testing.T) {
+	log.SetOutput(io.Discard)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the event was sent")
+	}))
+	defer server.Close()
+
+	os.Setenv("OUTREACH_TELEFORK_ENDPOINT", server.URL)
+	os.Setenv("DEVTEL_AGENT_SOCKET", filepath.Join(t.TempDir(), ".agent.sock"))
+
+	os.Setenv("DEVSPACE_PLUGIN_EVENT", "before:deploy")
+	os.Setenv("DEVSPACE_PLUGIN_EXECUTION_ID", "031cb474-c2f4-433f-863e-684c35c8d5ac")
+	os.Setenv("DEVSPACE_PLUGIN_COMMAND", "deploy")
+
+	var stderr bytes.Buffer
+	app := &cli.App{
+		Name:      "devtel",
+		ErrWriter: &stderr,
+		Commands: []*cli.Command{
+			track.NewCommand("testKey"),
+		},
+	}
+
+	assert.NoError(t, app.Run([]string{"devtel", "track", "--store=memory", "--dry-run", "--flush-max-events=1"}))
+	assert.Contains(t, stderr.String(), "before:deploy")
+	assert.Contains(t, stderr.String(), "031cb474-c2f4-433f-863e-684c35c8d5ac")
+	assert.Contains(t, stderr.String(), "1 events")
+
+	stderr.Reset()
+	assert.NoError(t, app.Run([]string{"devtel", "track", "--store=memory", "--dry-run", "--dry-run-format=json"}))
+	assert.Contains(t, stderr.String(), `"hook": "before:deploy"`)
+
+	assert.Error(t, app.Run([]string{"devtel", "track", "--store=memory", "--dry-run", "--dry-run-format=yaml"}))
+}
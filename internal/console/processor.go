@@ -0,0 +1,108 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the package documentation and the Processor printing the events.
+
+// Package console contains the processor printing the events, to see what devtel would send when debugging
+// the hooks.
+package console
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+)
+
+// Format is the format the events are printed in.
+type Format string
+
+// The formats the events can be printed in.
+const (
+	// FormatTable prints a line per event, with the main fields in columns.
+	FormatTable Format = "table"
+	// FormatJSON prints the events as indented JSON.
+	FormatJSON Format = "json"
+)
+
+// ParseFormat returns the named format.
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(name); f {
+	case FormatTable, FormatJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format %q", name)
+	}
+}
+
+// Processor prints the events to a writer.
+type Processor struct {
+	w      io.Writer
+	format Format
+}
+
+// NewProcessor returns a new Processor printing the events to w in the format.
+func NewProcessor(w io.Writer, format Format) *Processor {
+	return &Processor{
+		w:      w,
+		format: format,
+	}
+}
+
+// ProcessRecords prints the events.
+func (p *Processor) ProcessRecords(ctx context.Context, events []interface{}) error {
+	if p.format == FormatJSON {
+		return p.printJSON(events)
+	}
+	return p.printTable(events)
+}
+
+// printJSON prints the events as an indented JSON array.
+func (p *Processor) printJSON(events []interface{}) error {
+	if events == nil {
+		events = []interface{}{}
+	}
+
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(events)
+}
+
+// printTable prints a line per event, with the main fields in columns.
+func (p *Processor) printTable(events []interface{}) error {
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tHOOK\tEXECUTION ID\tCOMMAND\tSTATUS\tDURATION\tERROR")
+
+	for _, e := range events {
+		data, err := devspace.Fields(e)
+		if err != nil {
+			return err
+		}
+
+		var ts, duration string
+		if v := data.Int("timestamp"); v > 0 {
+			ts = time.UnixMilli(v).Format("15:04:05.000")
+		}
+		if v := data.Int("duration_ms"); v > 0 {
+			duration = (time.Duration(v) * time.Millisecond).String()
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			ts, text(data.String("hook")), text(data.String("execution_id")), text(data.String("command.name")),
+			text(data.String("status")), duration, text(data.String("error")))
+	}
+	fmt.Fprintf(tw, "%d events\n", len(events))
+
+	return tw.Flush()
+}
+
+// text returns the value as a table cell, "-" when it's missing.
+func text(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
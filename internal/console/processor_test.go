@@ -0,0 +1,60 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package console
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessorPrintsTable(t *testing.T) {
+	ts := time.Date(2022, 5, 1, 10, 15, 30, 0, time.Local).UnixMilli()
+	events := []interface{}{
+		map[string]interface{}{
+			"hook":         "after:deploy",
+			"execution_id": "1",
+			"status":       "info",
+			"command":      map[string]interface{}{"name": "deploy"},
+			"timestamp":    ts,
+			"duration_ms":  1500,
+		},
+		map[string]interface{}{"hook": "error:build", "error": "exit status 1"},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, NewProcessor(&buf, FormatTable).ProcessRecords(context.Background(), events))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 4)
+	assert.Equal(t, []string{"TIME", "HOOK", "EXECUTION", "ID", "COMMAND", "STATUS", "DURATION", "ERROR"},
+		strings.Fields(lines[0]))
+	assert.Equal(t, []string{"10:15:30.000", "after:deploy", "1", "deploy", "info", "1.5s", "-"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"error:build", "-", "-", "-", "exit", "status", "1"}, strings.Fields(lines[2]))
+	assert.Equal(t, "2 events", lines[3])
+}
+
+func TestProcessorPrintsJSON(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProcessor(&buf, FormatJSON)
+
+	assert.NoError(t, p.ProcessRecords(context.Background(), []interface{}{map[string]interface{}{"hook": "before:build"}}))
+	assert.Equal(t, "[\n  {\n    \"hook\": \"before:build\"\n  }\n]\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, p.ProcessRecords(context.Background(), nil))
+	assert.Equal(t, "[]\n", buf.String())
+}
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("json")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatJSON, f)
+
+	_, err = ParseFormat("yaml")
+	assert.Error(t, err)
+}
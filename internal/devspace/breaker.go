@@ -0,0 +1,172 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the circuit breaker that stops processing while the destination is unreachable.
+
+package devspace
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/getoutreach/gobox/pkg/log"
+	"github.com/getoutreach/gobox/pkg/trace"
+	"github.com/pkg/errors"
+)
+
+// ErrCircuitOpen is returned while the circuit breaker doesn't let the events through.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerOptions configure a CircuitBreaker.
+type BreakerOptions struct {
+	// StatePath is the file the state is persisted in, so that it's shared by the devtel processes.
+	// It's kept only in memory when empty.
+	StatePath string
+	// Threshold is the number of consecutive failures that opens the circuit. Defaults to 3.
+	Threshold int
+	// Cooldown is how long the circuit stays open. Defaults to 10 minutes.
+	Cooldown time.Duration
+}
+
+// breakerState is the persisted state of the circuit breaker.
+type breakerState struct {
+	Failures  int       `json:"failures"`
+	OpenUntil time.Time `json:"open_until"`
+}
+
+// CircuitBreaker wraps a Processor, and stops calling it after consecutive failures for a cool-down period,
+// so that the hooks don't wait on an unreachable destination. The events stay queued in the store meanwhile.
+// Once the cool-down passes, the next batch is let through. The circuit closes when it's processed,
+// and opens for another cool-down when it fails.
+//
+// A batch fails when none of its events were either accepted or rejected.
+type CircuitBreaker struct {
+	p    Processor
+	opts BreakerOptions
+
+	// state is used when the state is not persisted.
+	state breakerState
+	now   func() time.Time
+}
+
+// NewCircuitBreaker creates a new CircuitBreaker around p.
+func NewCircuitBreaker(p Processor, opts BreakerOptions) *CircuitBreaker {
+	if opts.Threshold <= 0 {
+		opts.Threshold = 3
+	}
+
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = 10 * time.Minute
+	}
+
+	return &CircuitBreaker{
+		p:    p,
+		opts: opts,
+		now:  time.Now,
+	}
+}
+
+// ProcessRecords processes the events with the wrapped processor, unless the circuit is open.
+func (b *CircuitBreaker) ProcessRecords(ctx context.Context, events []interface{}) error {
+	_, err := b.ProcessBatch(ctx, events)
+	return err
+}
+
+// ProcessBatch processes the events with the wrapped processor, unless the circuit is open.
+func (b *CircuitBreaker) ProcessBatch(ctx context.Context, events []interface{}) (Result, error) {
+	ctx = trace.StartCall(ctx, "breaker.ProcessBatch")
+	defer trace.EndCall(ctx)
+
+	if len(events) == 0 {
+		return processBatch(ctx, b.p, events)
+	}
+
+	state := b.load(ctx)
+	if b.now().Before(state.OpenUntil) {
+		trace.AddInfo(ctx, log.F{"breaker.open_until": state.OpenUntil.Format(time.RFC3339)})
+		return NewResult(len(events), Retryable), ErrCircuitOpen
+	}
+
+	result, err := processBatch(ctx, b.p, events)
+	if err != nil && result.Count(Retryable) == len(events) {
+		state.Failures++
+		if state.Failures >= b.opts.Threshold {
+			state.OpenUntil = b.now().Add(b.opts.Cooldown)
+		}
+		trace.AddInfo(ctx, log.F{"breaker.failures": state.Failures})
+	} else {
+		state = breakerState{}
+	}
+	b.save(ctx, state)
+
+	return result, trace.SetCallStatus(ctx, err)
+}
+
+// load reads the state of the circuit breaker. Missing or unreadable state is the closed circuit.
+func (b *CircuitBreaker) load(ctx context.Context) breakerState {
+	if b.opts.StatePath == "" {
+		return b.state
+	}
+
+	var state breakerState
+	data, err := os.ReadFile(b.opts.StatePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			trace.AddInfo(ctx, log.F{"breaker.load_error": err.Error()})
+		}
+		return state
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		trace.AddInfo(ctx, log.F{"breaker.load_error": err.Error()})
+		return breakerState{}
+	}
+
+	return state
+}
+
+// save persists the state of the circuit breaker. The file is replaced atomically, so that the other
+// processes never read a partial state.
+func (b *CircuitBreaker) save(ctx context.Context, state breakerState) {
+	b.state = state
+	if b.opts.StatePath == "" {
+		return
+	}
+
+	if err := writeFileAtomic(b.opts.StatePath, state); err != nil {
+		// The processing isn't affected, only the outcome of this batch is not counted.
+		trace.AddInfo(ctx, log.F{"breaker.save_error": err.Error()})
+	}
+}
+
+// writeFileAtomic writes v as JSON to a temporary file, and renames it to path.
+func writeFileAtomic(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	dir, base := filepath.Dir(path), filepath.Base(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+base+".*.tmp")
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck // Why: The file is renamed on success, this cleans up on the error paths.
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,84 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package devspace
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyProcessor fails while down is set, and counts the calls.
+type flakyProcessor struct {
+	down  bool
+	calls int
+}
+
+func (p *flakyProcessor) ProcessRecords(ctx context.Context, events []interface{}) error {
+	p.calls++
+	if p.down {
+		return fmt.Errorf("destination unreachable")
+	}
+	return nil
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	opts := BreakerOptions{StatePath: filepath.Join(t.TempDir(), ".breaker"), Threshold: 2, Cooldown: time.Minute}
+	p := &flakyProcessor{down: true}
+
+	// Each hook runs in its own process, the state is shared through the file.
+	breaker := func() *CircuitBreaker {
+		b := NewCircuitBreaker(p, opts)
+		b.now = func() time.Time { return now }
+		return b
+	}
+	events := []interface{}{map[string]string{"hook": "before:deploy"}}
+
+	for i := 0; i < 2; i++ {
+		_, err := breaker().ProcessBatch(ctx, events)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 2, p.calls)
+
+	// The circuit is open, the processor is not called.
+	result, err := breaker().ProcessBatch(ctx, events)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, Result{Retryable}, result)
+	assert.Equal(t, 2, p.calls)
+
+	// After the cool-down, a failed batch opens the circuit again right away.
+	now = now.Add(time.Minute)
+	assert.Error(t, breaker().ProcessRecords(ctx, events))
+	assert.ErrorIs(t, breaker().ProcessRecords(ctx, events), ErrCircuitOpen)
+	assert.Equal(t, 3, p.calls)
+
+	// A processed batch closes the circuit.
+	now = now.Add(time.Minute)
+	p.down = false
+	assert.NoError(t, breaker().ProcessRecords(ctx, events))
+	p.down = true
+	assert.Error(t, breaker().ProcessRecords(ctx, events))
+	assert.NotErrorIs(t, breaker().ProcessRecords(ctx, events), ErrCircuitOpen)
+	assert.Equal(t, 6, p.calls)
+}
+
+func TestCircuitBreakerInMemory(t *testing.T) {
+	p := &flakyProcessor{down: true}
+	b := NewCircuitBreaker(p, BreakerOptions{Threshold: 1})
+	events := []interface{}{map[string]string{"hook": "before:deploy"}}
+
+	assert.Error(t, b.ProcessRecords(context.Background(), events))
+	assert.ErrorIs(t, b.ProcessRecords(context.Background(), events), ErrCircuitOpen)
+	assert.Equal(t, 1, p.calls)
+
+	// Empty batches pass through, and don't close the circuit.
+	p.down = false
+	assert.NoError(t, b.ProcessRecords(context.Background(), nil))
+	assert.ErrorIs(t, b.ProcessRecords(context.Background(), events), ErrCircuitOpen)
+}
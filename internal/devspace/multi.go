@@ -0,0 +1,56 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the processor fanning the events out to multiple sinks.
+
+package devspace
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Sink is a named destination of the events. The name identifies the sink in the delivery state of the events.
+type Sink struct {
+	Name      string
+	Processor Processor
+}
+
+// MultiProcessor sends the events to multiple sinks. When it's flushed by an EventTracker, the delivery to each
+// sink is recorded in the store, so that a sink that failed gets only the events it missed on the next flush,
+// and the other sinks don't get them again. The events are processed once they're delivered to all the sinks.
+type MultiProcessor struct {
+	sinks []Sink
+}
+
+// NewMultiProcessor creates a new MultiProcessor sending the events to the sinks.
+func NewMultiProcessor(sinks ...Sink) *MultiProcessor {
+	return &MultiProcessor{sinks: sinks}
+}
+
+// ProcessRecords sends the events to all the sinks.
+func (m *MultiProcessor) ProcessRecords(ctx context.Context, events []interface{}) error {
+	_, err := m.ProcessBatch(ctx, events)
+	return err
+}
+
+// ProcessBatch sends the events to all the sinks, without tracking the delivery. An event is retryable when
+// a sink failed to process it, and rejected when a sink rejected it. It returns the error of the first failed sink.
+func (m *MultiProcessor) ProcessBatch(ctx context.Context, events []interface{}) (Result, error) {
+	result := NewResult(len(events), Accepted)
+
+	var err error
+	for _, sink := range m.sinks {
+		r, serr := processBatch(ctx, sink.Processor, events)
+		for i, o := range r {
+			if o == Retryable || (o == Rejected && result[i] == Accepted) {
+				result[i] = o
+			}
+		}
+		if serr != nil && err == nil {
+			err = errors.Wrapf(serr, "sink %s", sink.Name)
+		}
+	}
+
+	return result, err
+}
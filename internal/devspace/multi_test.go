@@ -0,0 +1,83 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package devspace
+
+import (
+	"context"
+	"testing"
+
+	"github.com/getoutreach/devtel/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlushRetriesOnlyFailedSinks(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	telefork := &partialProcessor{outcomes: Result{Accepted, Accepted}}
+	// The file sink gets the build, and fails on the deploy.
+	file := &partialProcessor{outcomes: Result{Accepted, Retryable}}
+	r := NewTracker(NewMultiProcessor(Sink{Name: "telefork", Processor: telefork}, Sink{Name: "file", Processor: file}), s)
+
+	r.Track(ctx, &Event{Hook: "before:build", ExecutionID: "1"})
+	r.Track(ctx, &Event{Hook: "before:deploy", ExecutionID: "1"})
+
+	assert.Error(t, r.Flush(ctx))
+	assert.Equal(t, []string{"before:build", "before:deploy"}, telefork.hooks)
+	assert.Equal(t, []string{"before:build", "before:deploy"}, file.hooks)
+	assert.Equal(t, 1, s.GetUnprocessed(ctx).Len())
+
+	// Only the file sink gets the deploy again.
+	telefork.hooks = nil
+	file.outcomes = Result{Accepted}
+	assert.NoError(t, r.Flush(ctx))
+	assert.Nil(t, telefork.hooks)
+	assert.Equal(t, []string{"before:deploy"}, file.hooks)
+	assert.Equal(t, 0, s.GetUnprocessed(ctx).Len())
+}
+
+func TestFlushKeepsEventsTrackedWhileSinksProcess(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	var r *EventTracker
+
+	telefork := &partialProcessor{outcomes: Result{Accepted}}
+	var fileHooks []string
+	file := processorFunc(func(ctx context.Context, events []interface{}) error {
+		for _, e := range events {
+			fileHooks = append(fileHooks, (*e.(*eventBag))["hook"].(string))
+		}
+		// The deploy is tracked while the last sink is processing, none of the sinks gets it.
+		if len(fileHooks) == 1 {
+			r.Track(ctx, &Event{Hook: "before:deploy", ExecutionID: "1"})
+		}
+		return nil
+	})
+	r = NewTracker(NewMultiProcessor(Sink{Name: "telefork", Processor: telefork}, Sink{Name: "file", Processor: file}), s)
+
+	r.Track(ctx, &Event{Hook: "before:build", ExecutionID: "1"})
+	assert.NoError(t, r.Flush(ctx))
+	assert.Equal(t, []string{"before:build"}, telefork.hooks)
+	assert.Equal(t, []string{"before:build"}, fileHooks)
+	assert.Equal(t, 1, s.GetUnprocessed(ctx).Len())
+
+	// Both sinks get the deploy on the next flush.
+	assert.NoError(t, r.Flush(ctx))
+	assert.Equal(t, []string{"before:deploy"}, telefork.hooks)
+	assert.Equal(t, []string{"before:build", "before:deploy"}, fileHooks)
+	assert.Equal(t, 0, s.GetUnprocessed(ctx).Len())
+}
+
+func TestMultiProcessorBatch(t *testing.T) {
+	first := &partialProcessor{outcomes: Result{Accepted, Rejected, Accepted}}
+	second := &partialProcessor{outcomes: Result{Rejected, Accepted, Retryable}}
+	m := NewMultiProcessor(Sink{Name: "first", Processor: first}, Sink{Name: "second", Processor: second})
+
+	events := []interface{}{
+		&eventBag{"hook": "before:build"},
+		&eventBag{"hook": "before:deploy"},
+		&eventBag{"hook": "before:purge"},
+	}
+	result, err := m.ProcessBatch(context.Background(), events)
+	assert.ErrorContains(t, err, "sink second")
+	assert.Equal(t, Result{Rejected, Rejected, Retryable}, result)
+}
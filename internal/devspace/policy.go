@@ -0,0 +1,75 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the policy deciding when the tracked events are flushed.
+
+package devspace
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/getoutreach/devtel/internal/store"
+)
+
+// FlushPolicy decides when the tracked events are flushed, so that the hooks don't send each event on its own.
+// The conditions are independent, the events are flushed when any of them is met. The zero value never flushes.
+type FlushPolicy struct {
+	// MaxEvents flushes once there are at least this many unprocessed events. Zero disables it.
+	MaxEvents int
+	// MaxAge flushes once the oldest unprocessed event is older than this. Zero disables it.
+	MaxAge time.Duration
+	// Hooks flushes after one of these hooks is tracked. The hooks with a sub-event specified match too.
+	Hooks []string
+}
+
+// DefaultFlushPolicy returns the policy that flushes when a devspace command finishes, or once 50 events
+// or events older than 10 minutes are waiting.
+func DefaultFlushPolicy() FlushPolicy {
+	return FlushPolicy{
+		MaxEvents: 50,
+		MaxAge:    10 * time.Minute,
+		Hooks: []string{
+			"devCommand:after:execute", "devCommand:error", "devCommand:interrupt",
+			"deployCommand:after:execute", "deployCommand:error", "deployCommand:interrupt",
+			"purgeCommand:after:execute", "purgeCommand:error", "purgeCommand:interrupt",
+			"buildCommand:after:execute", "buildCommand:error", "buildCommand:interrupt",
+			"command:after:execute", "command:error",
+		},
+	}
+}
+
+// due returns true if the events in the store should be flushed after event was tracked.
+// The event is nil when the check is not triggered by tracking one.
+func (p *FlushPolicy) due(ctx context.Context, s store.Store, event *Event, now time.Time) bool {
+	if event != nil {
+		for _, h := range p.Hooks {
+			if strings.HasPrefix(event.Hook, h) {
+				return true
+			}
+		}
+	}
+
+	if p.MaxEvents > 0 {
+		c := s.GetUnprocessed(ctx)
+		n := c.Len()
+		//nolint:errcheck // Why: Only the length was read.
+		c.Close()
+		if n >= p.MaxEvents {
+			return true
+		}
+	}
+
+	if p.MaxAge > 0 {
+		processed := false
+		c := s.Query(ctx, store.Filter{Processed: &processed, Until: now.Add(-p.MaxAge)})
+		n := c.Len()
+		//nolint:errcheck // Why: Only the length was read.
+		c.Close()
+		if n > 0 {
+			return true
+		}
+	}
+
+	return false
+}
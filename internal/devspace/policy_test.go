@@ -0,0 +1,60 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package devspace
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/getoutreach/devtel/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldFlushWithoutPolicy(t *testing.T) {
+	tracker := NewTracker(&testProcessor{}, store.NewMemory())
+	assert.True(t, tracker.ShouldFlush(context.Background(), &Event{Hook: "before:deploy"}))
+}
+
+func TestShouldFlushOnTerminalHooks(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewTracker(&testProcessor{}, store.NewMemory(), WithFlushPolicy(DefaultFlushPolicy()))
+
+	assert.False(t, tracker.ShouldFlush(ctx, &Event{Hook: "devCommand:before:execute"}))
+	assert.True(t, tracker.ShouldFlush(ctx, &Event{Hook: "devCommand:after:execute"}))
+	assert.True(t, tracker.ShouldFlush(ctx, &Event{Hook: "command:error"}))
+	assert.False(t, tracker.ShouldFlush(ctx, nil))
+}
+
+func TestShouldFlushOnMaxEvents(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	tracker := NewTracker(&testProcessor{}, store.NewMemory(), WithFlushPolicy(FlushPolicy{MaxEvents: 2}))
+	tracker.now = func() time.Time { return now }
+
+	tracker.Track(ctx, &Event{Hook: "before:build", ExecutionID: "1", Timestamp: now.UnixMilli()})
+	assert.False(t, tracker.ShouldFlush(ctx, nil))
+
+	tracker.Track(ctx, &Event{Hook: "before:deploy", ExecutionID: "1", Timestamp: now.UnixMilli()})
+	assert.True(t, tracker.ShouldFlush(ctx, nil))
+
+	// The processed events don't count.
+	assert.NoError(t, tracker.Flush(ctx))
+	assert.False(t, tracker.ShouldFlush(ctx, nil))
+}
+
+func TestShouldFlushOnMaxAge(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	tracker := NewTracker(&testProcessor{}, store.NewMemory(), WithFlushPolicy(FlushPolicy{MaxAge: time.Minute}))
+	tracker.now = func() time.Time { return now }
+
+	tracker.Track(ctx, &Event{Hook: "before:build", ExecutionID: "1", Timestamp: now.UnixMilli()})
+	assert.False(t, tracker.ShouldFlush(ctx, nil))
+
+	now = now.Add(time.Minute)
+	assert.False(t, tracker.ShouldFlush(ctx, nil))
+
+	now = now.Add(time.Millisecond)
+	assert.True(t, tracker.ShouldFlush(ctx, nil))
+}
@@ -5,9 +5,122 @@
 
 package devspace
 
-import "context"
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
 
 // Processor is the interface for processing stored events.
 type Processor interface {
 	ProcessRecords(context.Context, []interface{}) error
 }
+
+// Record is the fields of an event, as decoded from its JSON.
+type Record map[string]interface{}
+
+// Fields returns the fields of an event the processors are given, as decoded from its JSON. It's how
+// the processors read the events, whichever type the store restored them as.
+func Fields(event interface{}) (Record, error) {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	var data Record
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// String returns the string field at the path, e.g. command.name for the name of the command.
+// It returns "" when the field is missing or is not a string.
+func (r Record) String(path string) string {
+	s, _ := r.field(path).(string)
+	return s
+}
+
+// Int returns the number field at the path, e.g. duration_ms. It returns 0 when the field is missing
+// or is not a number.
+func (r Record) Int(path string) int64 {
+	n, _ := r.field(path).(float64)
+	return int64(n)
+}
+
+// field returns the field at the dotted path, nil when it's missing.
+func (r Record) field(path string) interface{} {
+	var v interface{} = map[string]interface{}(r)
+	for _, name := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[name]
+	}
+	return v
+}
+
+// PartialProcessor is implemented by the processors that can deliver a part of a batch.
+// The tracker then marks only the events that don't need to be processed again.
+type PartialProcessor interface {
+	Processor
+
+	// ProcessBatch processes the events and returns the outcome of each of them. The error is the reason
+	// the retryable events were not delivered.
+	ProcessBatch(context.Context, []interface{}) (Result, error)
+}
+
+// Outcome is the outcome of processing an event.
+type Outcome int
+
+const (
+	// Retryable events were not delivered, they're processed again on the next flush.
+	Retryable Outcome = iota
+	// Accepted events were delivered.
+	Accepted
+	// Rejected events can't ever be delivered, e.g. the destination refused them. They're dropped.
+	Rejected
+)
+
+// Result holds the outcomes of processing a batch, in the order of the events in the batch.
+type Result []Outcome
+
+// NewResult returns a result of n events with the same outcome.
+func NewResult(n int, o Outcome) Result {
+	r := make(Result, n)
+	for i := range r {
+		r[i] = o
+	}
+	return r
+}
+
+// Count returns the number of events with the outcome.
+func (r Result) Count(o Outcome) int {
+	var n int
+	for _, v := range r {
+		if v == o {
+			n++
+		}
+	}
+	return n
+}
+
+// processBatch processes the events with p, and returns the outcome of each of them.
+// Processors that can't deliver a part of a batch either deliver all the events or none of them.
+func processBatch(ctx context.Context, p Processor, events []interface{}) (Result, error) {
+	pp, ok := p.(PartialProcessor)
+	if !ok {
+		if err := p.ProcessRecords(ctx, events); err != nil {
+			return NewResult(len(events), Retryable), err
+		}
+		return NewResult(len(events), Accepted), nil
+	}
+
+	result, err := pp.ProcessBatch(ctx, events)
+	// The events without outcome were not processed.
+	for len(result) < len(events) {
+		result = append(result, Retryable)
+	}
+	return result[:len(events)], err
+}
@@ -5,6 +5,10 @@ package devspace
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 type testProcessor struct {
@@ -23,3 +27,54 @@ func (p *testProcessor) ProcessRecords(ctx context.Context, events []interface{}
 
 	return nil
 }
+
+// partialProcessor returns the given outcomes for the batch, and records the hooks of the processed events.
+type partialProcessor struct {
+	outcomes Result
+	hooks    []string
+}
+
+func (p *partialProcessor) ProcessRecords(ctx context.Context, events []interface{}) error {
+	_, err := p.ProcessBatch(ctx, events)
+	return err
+}
+
+func (p *partialProcessor) ProcessBatch(ctx context.Context, events []interface{}) (Result, error) {
+	p.hooks = nil
+	for _, e := range events {
+		p.hooks = append(p.hooks, (*e.(*eventBag))["hook"].(string))
+	}
+
+	if p.outcomes.Count(Retryable) > 0 {
+		return p.outcomes, fmt.Errorf("destination unavailable")
+	}
+	return p.outcomes, nil
+}
+
+// processorFunc processes the events with the function.
+type processorFunc func(ctx context.Context, events []interface{}) error
+
+func (f processorFunc) ProcessRecords(ctx context.Context, events []interface{}) error {
+	return f(ctx, events)
+}
+
+func TestRecordAccessors(t *testing.T) {
+	data, err := Fields(&Event{
+		Hook:      "after:deploy",
+		Timestamp: 1651388151749,
+		Duration:  9046,
+		Command:   &Command{Name: "deploy"},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "after:deploy", data.String("hook"))
+	assert.Equal(t, "deploy", data.String("command.name"))
+	assert.Equal(t, int64(1651388151749), data.Int("timestamp"))
+	assert.Equal(t, int64(9046), data.Int("duration_ms"))
+
+	// The missing fields, and the fields of another type, are zero.
+	assert.Empty(t, data.String("error"))
+	assert.Empty(t, data.String("hook.name"))
+	assert.Zero(t, data.Int("hook"))
+	assert.Zero(t, data.Int("devenv.runtime"))
+}
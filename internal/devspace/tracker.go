@@ -7,9 +7,13 @@ package devspace
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/getoutreach/devtel/internal/store"
+	"github.com/getoutreach/gobox/pkg/log"
 	"github.com/getoutreach/gobox/pkg/trace"
+	"github.com/pkg/errors"
 )
 
 // eventBag is internal tracked data bag data from store.
@@ -52,6 +56,35 @@ func (b eventBag) UnmarshalRecord(data map[string]interface{}) error {
 type EventTracker struct {
 	s store.Store
 	p Processor
+
+	// mu serializes the access to the store within the process, the stores are not safe for concurrent use.
+	// It's not held while the events are processed, so that tracking doesn't wait on the upload.
+	mu sync.Mutex
+
+	// policy decides when the events are flushed, they're flushed after every event when it's nil.
+	policy *FlushPolicy
+	now    func() time.Time
+
+	// dryRun processes the events on Flush without marking them, so that they stay queued.
+	dryRun bool
+}
+
+// TrackerOption configures an EventTracker.
+type TrackerOption func(*EventTracker)
+
+// WithFlushPolicy sets the policy ShouldFlush decides with.
+func WithFlushPolicy(policy FlushPolicy) TrackerOption {
+	return func(t *EventTracker) {
+		t.policy = &policy
+	}
+}
+
+// WithDryRun makes Flush process the events without marking them processed or delivered, so that they
+// stay queued for the next flush. It's meant for previewing the events with a debugging processor.
+func WithDryRun() TrackerOption {
+	return func(t *EventTracker) {
+		t.dryRun = true
+	}
 }
 
 // Tracker is the entry interface into event tracking, matching and processing.
@@ -61,34 +94,222 @@ type Tracker interface {
 }
 
 // NewTracker creates a new DevspaceTracker.
-func NewTracker(p Processor, s store.Store) *EventTracker {
-	return &EventTracker{
-		s: s,
-		p: p,
+func NewTracker(p Processor, s store.Store, opts ...TrackerOption) *EventTracker {
+	t := &EventTracker{
+		s:   s,
+		p:   p,
+		now: time.Now,
+	}
+	for _, opt := range opts {
+		opt(t)
 	}
+
+	return t
 }
 
 // Track stores and matches an event.
 func (t *EventTracker) Track(ctx context.Context, event *Event) {
+	//nolint:errcheck // Why: This is how we track it. There's not much else we should do. Definitely not crashing devspace.
+	t.TrackWithFields(ctx, event, nil)
+}
+
+// TrackWithFields stores and matches an event, with the fields added to it. Unlike the default fields
+// of the store, the fields are added to this event only. The fields of the event take precedence.
+// It returns the error of storing the event, so that the caller can track it some other way.
+func (t *EventTracker) TrackWithFields(ctx context.Context, event *Event, fields map[string]interface{}) error {
 	ctx = trace.StartCall(ctx, "tracker.Track")
 	defer trace.EndCall(ctx)
 
-	if before := t.tryGetBeforeHook(ctx, event); before != nil {
-		event = t.combineEvents(before, event)
+	// The before hook might have been tracked by another process since the store was initialized.
+	err := t.locked(ctx, func() error {
+		if before := t.tryGetBeforeHook(ctx, event); before != nil {
+			event = t.combineEvents(before, event)
+		}
+
+		if len(fields) == 0 {
+			return t.s.Append(ctx, event)
+		}
+		return t.s.Append(ctx, eventWithFields{Event: event, fields: fields})
+	})
+
+	return trace.SetCallStatus(ctx, err)
+}
+
+// eventWithFields adds fields to the record of an event.
+type eventWithFields struct {
+	*Event
+	fields map[string]interface{}
+}
+
+// MarshalRecord adds the fields, and then the fields of the event.
+func (e eventWithFields) MarshalRecord(addField func(name string, value interface{})) {
+	for k, v := range e.fields {
+		addField(k, v)
 	}
+	e.Event.MarshalRecord(addField)
+}
 
-	if err := t.s.Append(ctx, event); err != nil {
-		//nolint:errcheck // Why: This is how we track it. There's not much else we should do. Definitely not crashing devspace.
-		trace.SetCallStatus(ctx, err)
+// ShouldFlush returns true if the events should be flushed after event was tracked, according to the flush policy.
+// The event is nil when the check is not triggered by tracking one. Without a policy, it always returns true.
+func (t *EventTracker) ShouldFlush(ctx context.Context, event *Event) bool {
+	if t.policy == nil {
+		return true
 	}
+
+	ctx = trace.StartCall(ctx, "tracker.ShouldFlush")
+	defer trace.EndCall(ctx)
+
+	t.mu.Lock()
+	due := t.policy.due(ctx, t.s, event, t.now())
+	t.mu.Unlock()
+	trace.AddInfo(ctx, log.F{"tracker.flush_due": due})
+
+	return due
 }
 
-// Flush processes the events in the store.
+// Flush processes the events in the store. The store is locked only while the events are read and marked,
+// not while they're processed, so that the hooks tracking events don't wait on the upload. The callers make sure
+// there's a single flusher, e.g. with the flush lock of the pipeline.
 func (t *EventTracker) Flush(ctx context.Context) error {
 	ctx = trace.StartCall(ctx, "tracker.Flush")
 	defer trace.EndCall(ctx)
 
-	cursor := t.s.GetUnprocessed(ctx)
+	processed := false
+	if t.dryRun {
+		_, toProcess, err := t.read(ctx, store.Filter{Processed: &processed})
+		if err != nil {
+			return trace.SetCallStatus(ctx, err)
+		}
+		_, err = processBatch(ctx, t.p, toProcess)
+		return trace.SetCallStatus(ctx, err)
+	}
+
+	if m, ok := t.p.(*MultiProcessor); ok {
+		return trace.SetCallStatus(ctx, t.flushSinks(ctx, m))
+	}
+
+	events, toProcess, err := t.read(ctx, store.Filter{Processed: &processed})
+	if err != nil {
+		return trace.SetCallStatus(ctx, err)
+	}
+
+	result, err := processBatch(ctx, t.p, toProcess)
+
+	// The rejected events are marked too, so that they're not sent again and again.
+	var done []store.IndexMarshaller
+	for i, o := range result {
+		if o != Retryable {
+			done = append(done, events[i])
+		}
+	}
+	if rejected := result.Count(Rejected); rejected > 0 {
+		trace.AddInfo(ctx, log.F{"tracker.rejected_events": rejected})
+	}
+
+	if merr := t.locked(ctx, func() error { return t.s.MarkProcessed(ctx, done) }); merr != nil {
+		return trace.SetCallStatus(ctx, merr)
+	}
+
+	return trace.SetCallStatus(ctx, err)
+}
+
+// flushSinks sends each sink the events that were not delivered to it, and records the delivery. The events
+// delivered to all the sinks are marked processed. It returns the error of the first failed sink.
+func (t *EventTracker) flushSinks(ctx context.Context, m *MultiProcessor) error {
+	processed := false
+
+	var err error
+	for _, sink := range m.sinks {
+		events, toProcess, rerr := t.read(ctx, store.Filter{Processed: &processed, Undelivered: sink.Name})
+		if rerr != nil {
+			return rerr
+		}
+		if len(events) == 0 {
+			continue
+		}
+
+		result, serr := processBatch(ctx, sink.Processor, toProcess)
+		if serr != nil && err == nil {
+			err = errors.Wrapf(serr, "sink %s", sink.Name)
+		}
+
+		var delivered []store.IndexMarshaller
+		for i, o := range result {
+			if o != Retryable {
+				delivered = append(delivered, events[i])
+			}
+		}
+		trace.AddInfo(ctx, log.F{
+			"tracker.sink":             sink.Name,
+			"tracker.delivered_events": len(delivered),
+			"tracker.rejected_events":  result.Count(Rejected),
+		})
+
+		if merr := t.locked(ctx, func() error { return t.s.MarkDelivered(ctx, sink.Name, delivered) }); merr != nil {
+			return merr
+		}
+	}
+
+	// The delivery is read from the store, so that the events tracked while the sinks were processing,
+	// which some sinks didn't get, are not marked.
+	return t.locked(ctx, func() error {
+		undelivered := make(map[string]bool)
+		for _, sink := range m.sinks {
+			events, _ := t.events(ctx, store.Filter{Processed: &processed, Undelivered: sink.Name})
+			for _, e := range events {
+				undelivered[e.Key()] = true
+			}
+		}
+
+		events, _ := t.events(ctx, store.Filter{Processed: &processed})
+		var done []store.IndexMarshaller
+		for _, e := range events {
+			if !undelivered[e.Key()] {
+				done = append(done, e)
+			}
+		}
+		if merr := t.s.MarkProcessed(ctx, done); merr != nil {
+			return merr
+		}
+
+		return err
+	})
+}
+
+// read reads the events matching the filter from the store, under the store lock.
+func (t *EventTracker) read(ctx context.Context, f store.Filter) ([]store.IndexMarshaller, []interface{}, error) {
+	var events []store.IndexMarshaller
+	var toProcess []interface{}
+	err := t.locked(ctx, func() error {
+		events, toProcess = t.events(ctx, f)
+		return nil
+	})
+
+	return events, toProcess, err
+}
+
+// locked calls fn with the store locked, so that the reads and writes of fn are not interleaved with
+// the other processes, nor the other goroutines.
+func (t *EventTracker) locked(ctx context.Context, fn func() error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	unlock, err := t.s.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return fn()
+}
+
+// events reads the events matching the filter from the store. The events are returned twice,
+// for marking them in the store, and for processing them.
+func (t *EventTracker) events(ctx context.Context, f store.Filter) ([]store.IndexMarshaller, []interface{}) {
+	cursor := t.s.Query(ctx, f)
+	//nolint:errcheck // Why: The events are only read.
+	defer cursor.Close()
+
 	// Generics are bad. bad. We don't want generics.
 	var events []store.IndexMarshaller
 	var toProcess []interface{}
@@ -103,15 +324,11 @@ func (t *EventTracker) Flush(ctx context.Context) error {
 		toProcess = append(toProcess, &b)
 	}
 
-	err := t.p.ProcessRecords(ctx, toProcess)
-	if err != nil {
-		return trace.SetCallStatus(ctx, err)
-	}
-
-	return trace.SetCallStatus(ctx, t.s.MarkProcessed(ctx, events))
+	return events, toProcess
 }
 
-// tryGetBeforeHook tries to get the before hook event for given event.
+// tryGetBeforeHook tries to get the before hook event for given event. It returns nil when the before hook
+// wasn't tracked, the store leaves the value untouched for the missing keys.
 func (t *EventTracker) tryGetBeforeHook(ctx context.Context, event *Event) *Event {
 	beforeHook := getBeforeHook(event.Hook)
 	if beforeHook == "" {
@@ -124,7 +341,7 @@ func (t *EventTracker) tryGetBeforeHook(ctx context.Context, event *Event) *Even
 	}
 
 	var val Event
-	if err := t.s.Get(ctx, beforeKey, &val); err != nil {
+	if err := t.s.Get(ctx, beforeKey, &val); err != nil || val.Timestamp == 0 {
 		return nil
 	}
 	return &val
@@ -8,6 +8,7 @@ import (
 	"io"
 	"testing"
 	"testing/fstest"
+	"time"
 
 	"github.com/getoutreach/devtel/internal/store"
 	"github.com/getoutreach/gobox/pkg/log"
@@ -99,12 +100,7 @@ func TestEventWrittenToBuffer(t *testing.T) {
 }
 
 func TestEventMatched(t *testing.T) {
-	var buff store.TestClosableBuffer
-	s := store.New(&store.Options{
-		OpenAppend: func(key string) (io.WriteCloser, error) {
-			return &buff, nil
-		},
-	})
+	s := store.NewMemory()
 	r := NewTracker(&testProcessor{}, s)
 
 	var before, after Event
@@ -118,6 +114,18 @@ func TestEventMatched(t *testing.T) {
 	assert.Equal(t, int64(9046), after.Duration)
 }
 
+func TestEventWithoutBeforeHookHasNoDuration(t *testing.T) {
+	s := store.NewMemory()
+	r := NewTracker(&testProcessor{}, s)
+
+	var after Event
+	assert.NoError(t, json.Unmarshal([]byte(afterEvent), &after))
+	r.Track(context.Background(), &after)
+
+	assert.NoError(t, s.Get(context.Background(), "9714f00a-b998-49e7-97a9-a8e2051905f7_after:deploy", &after))
+	assert.Zero(t, after.Duration)
+}
+
 func TestCanUseRestoredEvents(t *testing.T) {
 	logFS := make(fstest.MapFS)
 	logFS["1.log"] = &fstest.MapFile{
@@ -143,13 +151,8 @@ func TestCanUseRestoredEvents(t *testing.T) {
 }
 
 func TestCanProcessEvents(t *testing.T) {
-	var buff store.TestClosableBuffer
 	p := &testProcessor{}
-	s := store.New(&store.Options{
-		OpenAppend: func(key string) (io.WriteCloser, error) {
-			return &buff, nil
-		},
-	})
+	s := store.NewMemory()
 	r := NewTracker(p, s)
 
 	var before, after Event
@@ -169,13 +172,8 @@ func TestCanProcessEvents(t *testing.T) {
 }
 
 func TestProcessesWithDefaultFields(t *testing.T) {
-	var buff store.TestClosableBuffer
 	p := &testProcessor{}
-	s := store.New(&store.Options{
-		OpenAppend: func(key string) (io.WriteCloser, error) {
-			return &buff, nil
-		},
-	})
+	s := store.NewMemory()
 	r := NewTracker(p, s)
 	assert.NoError(t, s.Init(context.Background()))
 
@@ -191,3 +189,71 @@ func TestProcessesWithDefaultFields(t *testing.T) {
 
 	assert.Contains(t, p.lastBatch[0], `"defaultField":"present"`)
 }
+
+func TestFlushMarksOnlyDeliveredEvents(t *testing.T) {
+	s := store.NewMemory()
+	// The deploy is delivered, the build is rejected and the purge is to be retried.
+	p := &partialProcessor{outcomes: Result{Accepted, Rejected, Retryable}}
+	r := NewTracker(p, s)
+
+	for _, hook := range []string{"before:deploy", "before:build", "before:purge"} {
+		r.Track(context.Background(), &Event{Hook: hook, ExecutionID: "1"})
+	}
+
+	assert.Error(t, r.Flush(context.Background()))
+	assert.Equal(t, []string{"before:deploy", "before:build", "before:purge"}, p.hooks)
+
+	p.outcomes = Result{Accepted}
+	assert.NoError(t, r.Flush(context.Background()))
+	assert.Equal(t, []string{"before:purge"}, p.hooks)
+	assert.Equal(t, 0, s.GetUnprocessed(context.Background()).Len())
+}
+
+func TestFlushDoesNotLockStoreWhileProcessing(t *testing.T) {
+	dir := t.TempDir()
+	s := store.New(&store.Options{LogDir: dir})
+	assert.NoError(t, s.Init(context.Background()))
+
+	// Another process tracks an event while the events are uploaded.
+	other := store.New(&store.Options{LogDir: dir})
+	assert.NoError(t, other.Init(context.Background()))
+	p := processorFunc(func(ctx context.Context, events []interface{}) error {
+		tracked := make(chan struct{})
+		go func() {
+			NewTracker(&testProcessor{}, other).Track(ctx, &Event{Hook: "before:build", ExecutionID: "1"})
+			close(tracked)
+		}()
+
+		select {
+		case <-tracked:
+		case <-time.After(5 * time.Second):
+			t.Error("the store was locked while processing")
+		}
+		return nil
+	})
+
+	r := NewTracker(p, s)
+	r.Track(context.Background(), &Event{Hook: "before:deploy", ExecutionID: "1"})
+	assert.NoError(t, r.Flush(context.Background()))
+
+	// Only the uploaded event is marked.
+	assert.Equal(t, 1, s.GetUnprocessed(context.Background()).Len())
+}
+
+func TestDryRunFlushKeepsEventsQueued(t *testing.T) {
+	p := &testProcessor{}
+	s := store.NewMemory()
+	r := NewTracker(p, s, WithDryRun())
+
+	r.Track(context.Background(), &Event{Hook: "before:deploy", ExecutionID: "1"})
+	r.Track(context.Background(), &Event{Hook: "before:build", ExecutionID: "1"})
+
+	assert.NoError(t, r.Flush(context.Background()))
+	assert.Len(t, p.lastBatch, 2)
+	assert.Equal(t, 2, s.GetUnprocessed(context.Background()).Len())
+
+	// The events are still sent by the real flush.
+	assert.NoError(t, NewTracker(p, s).Flush(context.Background()))
+	assert.Len(t, p.lastBatch, 2)
+	assert.Equal(t, 0, s.GetUnprocessed(context.Background()).Len())
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the splitting of event batches into chunks sent in separate requests.
+
+package httpsend
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// BatchLimits limit the size of the requests. Batches over the limits are sent in chunks.
+// Zero disables the limit. An event that is larger than MaxBytes on its own is sent in a chunk by itself.
+type BatchLimits struct {
+	MaxEvents int
+	MaxBytes  int
+}
+
+// DefaultBatchLimits returns the batch limits used by the processors unless configured otherwise.
+func DefaultBatchLimits() BatchLimits {
+	return BatchLimits{
+		MaxEvents: 500,
+		MaxBytes:  1 << 20,
+	}
+}
+
+// Chunk is the part of a batch of events sent in a single request.
+type Chunk struct {
+	// Start and End are the indexes of the chunk events in the batch, End is exclusive.
+	Start int
+	End   int
+	// Body is the JSON array of the chunk events.
+	Body []byte
+	// Err is set for an event that couldn't be marshaled. It's in a chunk by itself, without a body.
+	Err error
+}
+
+// Split marshals the events and packs them into chunks within the limits, the body of a chunk is the JSON array
+// of its events. The events that can't be marshaled are skipped, each in a chunk by itself with the error,
+// so that they don't fail the rest of the batch. The chunks are not sent.
+func (l *BatchLimits) Split(events []interface{}) []Chunk {
+	var chunks []Chunk
+	var body bytes.Buffer
+	start := 0
+
+	flush := func(end int) {
+		body.WriteByte(']')
+		chunks = append(chunks, Chunk{Start: start, End: end, Body: append([]byte(nil), body.Bytes()...)})
+		body.Reset()
+		start = end
+	}
+
+	for i, event := range events {
+		b, err := json.Marshal(event)
+		if err != nil {
+			if i > start {
+				flush(i)
+			}
+			chunks = append(chunks, Chunk{Start: i, End: i + 1, Err: errors.Wrapf(err, "failed to marshal event %d", i)})
+			start = i + 1
+			continue
+		}
+
+		if i > start {
+			full := l.MaxEvents > 0 && i-start >= l.MaxEvents
+			// The chunk is closed by the bracket, the next event is prepended with a comma.
+			overflow := l.MaxBytes > 0 && body.Len()+1+len(b)+1 > l.MaxBytes
+			if full || overflow {
+				flush(i)
+			}
+		}
+
+		if i == start {
+			body.WriteByte('[')
+		} else {
+			body.WriteByte(',')
+		}
+		body.Write(b)
+	}
+
+	if len(events) > start {
+		flush(len(events))
+	}
+
+	return chunks
+}
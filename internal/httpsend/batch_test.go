@@ -0,0 +1,66 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package httpsend_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/getoutreach/devtel/internal/httpsend"
+	"github.com/getoutreach/devtel/internal/httpsend/httpsendtest"
+)
+
+func TestSplit(t *testing.T) {
+	// Each event is 24 bytes long.
+	tests := []struct {
+		name   string
+		limits httpsend.BatchLimits
+		events int
+		want   [][2]int
+	}{
+		{"no limits", httpsend.BatchLimits{}, 5, [][2]int{{0, 5}}},
+		{"max events", httpsend.BatchLimits{MaxEvents: 2}, 5, [][2]int{{0, 2}, {2, 4}, {4, 5}}},
+		{"max bytes", httpsend.BatchLimits{MaxBytes: 2 + 3*24 + 2}, 5, [][2]int{{0, 3}, {3, 5}}},
+		{"event over max bytes", httpsend.BatchLimits{MaxBytes: 10}, 2, [][2]int{{0, 1}, {1, 2}}},
+		{"empty", httpsend.BatchLimits{MaxEvents: 2}, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := tt.limits.Split(httpsendtest.Batch(tt.events))
+
+			var got [][2]int
+			for _, c := range chunks {
+				got = append(got, [2]int{c.Start, c.End})
+
+				var decoded []interface{}
+				assert.NoError(t, json.Unmarshal(c.Body, &decoded))
+				assert.Len(t, decoded, c.End-c.Start)
+				if tt.limits.MaxBytes > 0 && c.End-c.Start > 1 {
+					assert.LessOrEqual(t, len(c.Body), tt.limits.MaxBytes)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSplitSkipsUnmarshalableEvents(t *testing.T) {
+	events := httpsendtest.Batch(4)
+	events[1] = func() {}
+
+	limits := httpsend.BatchLimits{MaxEvents: 2}
+	chunks := limits.Split(events)
+
+	var got [][2]int
+	for _, c := range chunks {
+		got = append(got, [2]int{c.Start, c.End})
+	}
+	assert.Equal(t, [][2]int{{0, 1}, {1, 2}, {2, 4}}, got)
+	assert.NoError(t, chunks[0].Err)
+	assert.Error(t, chunks[1].Err)
+	assert.Nil(t, chunks[1].Body)
+	assert.Equal(t, `[{"hook":"before:deploy"},{"hook":"before:deploy"}]`, string(chunks[2].Body))
+}
@@ -0,0 +1,129 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the package documentation and the Sender posting the request bodies.
+
+// Package httpsend contains what the processors sending the events over HTTP share: posting the request bodies
+// with retries, splitting the batches of events within limits, and parsing the headers configured
+// by the environment variables.
+package httpsend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is the timeout of each request of the HTTP clients that have none.
+const DefaultTimeout = 10 * time.Second
+
+// WithDefaultTimeout returns the client with DefaultTimeout when it has no timeout, so that a stalled server
+// doesn't hold the flush, http.DefaultClient has none. The client is copied, so that the one passed in can be
+// shared with others.
+func WithDefaultTimeout(client *http.Client) *http.Client {
+	if client.Timeout > 0 {
+		return client
+	}
+
+	c := *client
+	c.Timeout = DefaultTimeout
+	return &c
+}
+
+// Sender posts request bodies to a URL, retrying the failed requests according to the retry policy.
+type Sender struct {
+	// Client makes the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+	URL    string
+	Header http.Header
+	Retry  RetryPolicy
+	// Accept returns true for the status codes of the successful responses. Defaults to any 2xx.
+	Accept func(statusCode int) bool
+}
+
+// Post posts the body, retrying according to the retry policy. The responses with a status code that is not
+// accepted fail with a StatusError.
+func (s *Sender) Post(ctx context.Context, body []byte) error {
+	return s.Retry.Do(ctx, func() (bool, time.Duration, error) {
+		return s.send(ctx, body)
+	})
+}
+
+// send posts the body once. On failure, it returns whether the request can be retried, and how long the server
+// asked to wait before that.
+func (s *Sender) send(ctx context.Context, body []byte) (retry bool, after time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, 0, err
+	}
+	for k, v := range s.Header {
+		req.Header[k] = v
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// The request was cancelled when the context is done, the other errors are network errors.
+		return ctx.Err() == nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	//nolint:errcheck // Why: The body is drained only so that the connection can be reused.
+	io.Copy(io.Discard, resp.Body)
+
+	if s.accepted(resp.StatusCode) {
+		return false, 0, nil
+	}
+
+	retry, after = s.Retry.CanRetry(resp, time.Now())
+	return retry, after, &StatusError{StatusCode: resp.StatusCode}
+}
+
+// accepted returns true if the response with the status code is a success.
+func (s *Sender) accepted(statusCode int) bool {
+	if s.Accept != nil {
+		return s.Accept(statusCode)
+	}
+	return statusCode >= 200 && statusCode < 300
+}
+
+// StatusError is the error of a request that the server responded to with an unexpected status code.
+type StatusError struct {
+	StatusCode int
+}
+
+// Error implements error.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Unexpected status code: %d", e.StatusCode)
+}
+
+// Rejected returns true if the server refused the payload itself, sending it again would fail the same way.
+func (e *StatusError) Rejected() bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseHeaders parses the headers configured as comma separated key=value pairs, e.g. by an environment
+// variable. Malformed pairs are skipped.
+func ParseHeaders(s string) http.Header {
+	headers := make(http.Header)
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) != "" {
+			headers.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+		}
+	}
+
+	return headers
+}
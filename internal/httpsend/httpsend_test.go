@@ -0,0 +1,53 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package httpsend
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostAccept(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sender := &Sender{Client: server.Client(), URL: server.URL, Header: http.Header{"X-Team": {"devenv"}}}
+	assert.NoError(t, sender.Post(context.Background(), []byte(`[]`)))
+	assert.Equal(t, "devenv", header.Get("X-Team"))
+
+	sender.Accept = func(statusCode int) bool { return statusCode == http.StatusCreated }
+	err := sender.Post(context.Background(), []byte(`[]`))
+	var se *StatusError
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, http.StatusOK, se.StatusCode)
+	assert.False(t, se.Rejected())
+	assert.True(t, (&StatusError{StatusCode: http.StatusRequestEntityTooLarge}).Rejected())
+}
+
+func TestWithDefaultTimeout(t *testing.T) {
+	client := WithDefaultTimeout(http.DefaultClient)
+	assert.Equal(t, DefaultTimeout, client.Timeout)
+	assert.Zero(t, http.DefaultClient.Timeout)
+
+	client = &http.Client{Timeout: time.Second}
+	assert.Same(t, client, WithDefaultTimeout(client))
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers := ParseHeaders("api-key=secret, x-team = dev=env ,malformed,=empty,x-team=ops")
+	assert.Equal(t, http.Header{
+		"Api-Key": {"secret"},
+		"X-Team":  {"dev=env", "ops"},
+	}, headers)
+	assert.Empty(t, ParseHeaders(""))
+}
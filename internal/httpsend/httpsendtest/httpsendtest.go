@@ -0,0 +1,40 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the package documentation and the fixtures of the tests.
+
+// Package httpsendtest contains the fixtures of the tests of the processors sending the events over HTTP.
+package httpsendtest
+
+import (
+	"time"
+
+	"github.com/getoutreach/devtel/internal/httpsend"
+)
+
+// RetryPolicy returns a retry policy with waits short enough for the tests.
+func RetryPolicy() httpsend.RetryPolicy {
+	return httpsend.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     4 * time.Millisecond,
+		MaxRetryAfter:  time.Second,
+	}
+}
+
+// Events returns an event of each of the hooks, the way the processors are given them.
+func Events(hooks ...string) []interface{} {
+	events := make([]interface{}, 0, len(hooks))
+	for _, hook := range hooks {
+		events = append(events, map[string]interface{}{"hook": hook})
+	}
+	return events
+}
+
+// Batch returns a batch of n before:deploy events, the JSON of each is 24 bytes long.
+func Batch(n int) []interface{} {
+	hooks := make([]string, n)
+	for i := range hooks {
+		hooks[i] = "before:deploy"
+	}
+	return Events(hooks...)
+}
@@ -0,0 +1,154 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the retry policy of the requests.
+
+package httpsend
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/getoutreach/gobox/pkg/log"
+	"github.com/getoutreach/gobox/pkg/trace"
+)
+
+// RetryPolicy configures how the failed requests are retried. The requests are retried on network errors,
+// 408, 429 and 5xx responses, with jittered exponential backoff. The retries never wait past the deadline
+// of the context, the last error is returned instead.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, including the first one. Values below 2 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, it's doubled for each next one up to MaxBackoff.
+	// Each wait is jittered to between half and the whole of it.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// MaxRetryAfter is the longest Retry-After of 429 and 503 responses that is honored.
+	// The request is not retried when the server asks to wait longer.
+	MaxRetryAfter time.Duration
+}
+
+// DefaultRetryPolicy returns the retry policy used by the processors unless configured otherwise.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 250 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		MaxRetryAfter:  5 * time.Second,
+	}
+}
+
+// Do makes the request with send, retrying it according to the policy. On failure, send returns whether
+// the request can be retried, and how long the server asked to wait before that, zero for the backoff.
+// It returns the error of the last attempt.
+func (p *RetryPolicy) Do(ctx context.Context, send func() (retry bool, after time.Duration, err error)) error {
+	for attempt := 1; ; attempt++ {
+		retry, delay, err := send()
+		if err == nil || !retry || attempt >= p.MaxAttempts {
+			return err
+		}
+
+		if delay == 0 {
+			delay = p.backoff(attempt)
+		}
+		trace.AddInfo(ctx, log.F{
+			"retry.attempt":  attempt,
+			"retry.retry_in": delay.String(),
+			"retry.error":    err.Error(),
+		})
+
+		if !wait(ctx, delay) {
+			return err
+		}
+	}
+}
+
+// CanRetry returns whether the request that failed with the response can be retried, and how long the server
+// asked to wait before that. The request is not retried when the server asks to wait longer than MaxRetryAfter.
+func (p *RetryPolicy) CanRetry(resp *http.Response, now time.Time) (bool, time.Duration) {
+	if !retryable(resp.StatusCode) {
+		return false, 0
+	}
+
+	if d, ok := retryAfter(resp, now); ok {
+		return d <= p.MaxRetryAfter, d
+	}
+
+	return true, 0
+}
+
+// backoff returns the wait before the given retry, counted from 1.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	//nolint:gosec // Why: The jitter doesn't need a secure random source.
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryable returns true if the request that ended with the status code can be retried.
+func retryable(statusCode int) bool {
+	switch {
+	case statusCode == http.StatusRequestTimeout, statusCode == http.StatusTooManyRequests:
+		return true
+	case statusCode >= 500 && statusCode != http.StatusNotImplemented:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryAfter parses the Retry-After header of 429 and 503 responses. It's either seconds or an HTTP date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// wait sleeps for d, unless the context is done or its deadline comes first. It returns false if it didn't wait.
+func wait(ctx context.Context, d time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return false
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
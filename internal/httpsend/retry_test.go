@@ -0,0 +1,127 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package httpsend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// respondWith returns a server that responds with the given status codes in order, and 201 afterwards.
+func respondWith(attempts *int32, responses ...func(w http.ResponseWriter)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(atomic.AddInt32(attempts, 1)) - 1
+		if i < len(responses) {
+			responses[i](w)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+}
+
+func status(code int, headers ...string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		for i := 0; i+1 < len(headers); i += 2 {
+			w.Header().Set(headers[i], headers[i+1])
+		}
+		w.WriteHeader(code)
+	}
+}
+
+func testRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond, MaxRetryAfter: time.Second}
+}
+
+func TestPostRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []func(w http.ResponseWriter)
+		err       bool
+		attempts  int32
+	}{
+		{"succeeds after transient errors", []func(w http.ResponseWriter){
+			status(http.StatusBadGateway), status(http.StatusTooManyRequests, "Retry-After", "0"),
+		}, false, 3},
+		{"gives up after max attempts", []func(w http.ResponseWriter){
+			status(http.StatusBadGateway), status(http.StatusBadGateway), status(http.StatusBadGateway),
+		}, true, 3},
+		{"doesn't retry client errors", []func(w http.ResponseWriter){
+			status(http.StatusBadRequest),
+		}, true, 1},
+		{"doesn't wait for long Retry-After", []func(w http.ResponseWriter){
+			status(http.StatusServiceUnavailable, "Retry-After", "120"),
+		}, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			server := respondWith(&attempts, tt.responses...)
+			defer server.Close()
+
+			sender := &Sender{Client: server.Client(), URL: server.URL, Retry: testRetryPolicy()}
+			err := sender.Post(context.Background(), []byte(`[{"hook":"before:deploy"}]`))
+			if tt.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.attempts, atomic.LoadInt32(&attempts))
+		})
+	}
+}
+
+func TestPostStopsAtDeadline(t *testing.T) {
+	var attempts int32
+	server := respondWith(&attempts, status(http.StatusBadGateway), status(http.StatusBadGateway))
+	defer server.Close()
+
+	policy := testRetryPolicy()
+	policy.InitialBackoff = time.Minute
+	policy.MaxBackoff = time.Minute
+	sender := &Sender{Client: server.Client(), URL: server.URL, Retry: policy}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	assert.Error(t, sender.Post(ctx, []byte(`[{"hook":"before:deploy"}]`)))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	resp := func(code int, v string) *http.Response {
+		return &http.Response{StatusCode: code, Header: http.Header{"Retry-After": []string{v}}}
+	}
+
+	d, ok := retryAfter(resp(http.StatusTooManyRequests, "3"), now)
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, d)
+
+	d, ok = retryAfter(resp(http.StatusServiceUnavailable, now.Add(time.Minute).Format(http.TimeFormat)), now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, d)
+
+	_, ok = retryAfter(resp(http.StatusBadGateway, "3"), now)
+	assert.False(t, ok)
+
+	_, ok = retryAfter(resp(http.StatusTooManyRequests, "soon"), now)
+	assert.False(t, ok)
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	for retry, limit := range map[int]time.Duration{1: 100, 2: 200, 3: 300, 10: 300} {
+		d := p.backoff(retry)
+		assert.GreaterOrEqual(t, d, limit*time.Millisecond/2)
+		assert.LessOrEqual(t, d, limit*time.Millisecond)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the client the hooks send the events to the agent with.
+
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+
+	"github.com/getoutreach/gobox/pkg/trace"
+	"github.com/pkg/errors"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+)
+
+// Send hands the event over to the agent listening on the Unix socket at path, with the default fields added to it.
+// It fails right away when the agent is not running, the caller is expected to track the event itself then.
+func Send(ctx context.Context, path string, event *devspace.Event, fields map[string]interface{}) error {
+	ctx = trace.StartCall(ctx, "ingest.Send")
+	defer trace.EndCall(ctx)
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		// The agent not running is the common case, it's not traced as an error.
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		//nolint:errcheck // Why: Without the deadline the exchange fails only when the agent gives up.
+		conn.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(conn).Encode(request{Event: event, Fields: fields}); err != nil {
+		return trace.SetCallStatus(ctx, errors.Wrap(err, "failed to send event"))
+	}
+
+	var resp response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return trace.SetCallStatus(ctx, errors.Wrap(err, "failed to read response"))
+	}
+	if resp.Error != "" {
+		return trace.SetCallStatus(ctx, errors.New(resp.Error))
+	}
+
+	return nil
+}
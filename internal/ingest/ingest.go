@@ -0,0 +1,251 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the package documentation and the server the agent tracks the events with.
+
+// Package ingest contains the server of the devtel agent, that owns the store and tracks the events sent to it
+// over a Unix socket, and the client the hooks send the events with.
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/getoutreach/gobox/pkg/log"
+	"github.com/getoutreach/gobox/pkg/trace"
+	"github.com/pkg/errors"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+	"github.com/getoutreach/devtel/internal/store"
+)
+
+// requestTimeout bounds the exchange of a single event, so that a stuck agent doesn't hold the hook.
+const requestTimeout = 5 * time.Second
+
+// defaultShutdownFlushTimeout bounds the last flush, when the agent shuts down, without a flush timeout.
+const defaultShutdownFlushTimeout = time.Minute
+
+// request is sent by the client for each event, one per connection.
+type request struct {
+	Event *devspace.Event `json:"event"`
+	// Fields are added to the event, they're of the client that sent it.
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+// response acknowledges the request once the event is tracked.
+type response struct {
+	Error string `json:"error,omitempty"`
+}
+
+// Options configure a Server.
+type Options struct {
+	// FlushInterval is how often the tracked events are flushed. Defaults to 30 seconds.
+	FlushInterval time.Duration
+	// LockFlush acquires the single-flusher lock shared with the devtel flush processes. It returns false
+	// if another process is flushing. The events are flushed without the lock when it's nil.
+	LockFlush func() (func(), bool, error)
+	// FlushPolicy flushes the events before the flush interval passes, when it's met after an event is tracked.
+	FlushPolicy devspace.FlushPolicy
+	// FlushTimeout bounds each flush, so that a stalled sink doesn't hold the flush lock. Zero disables it,
+	// except for the last flush when the server stops, which is always bounded.
+	FlushTimeout time.Duration
+}
+
+// Server tracks the events sent to it in the store, and flushes them periodically.
+type Server struct {
+	t    *devspace.EventTracker
+	opts Options
+
+	wg sync.WaitGroup
+	// due wakes the flush loop up when the flush policy is met.
+	due chan struct{}
+}
+
+// NewServer creates a new Server that tracks the events in s, and flushes them to p.
+// The store must be initialized.
+func NewServer(s store.Store, p devspace.Processor, opts Options) *Server {
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 30 * time.Second
+	}
+
+	return &Server{
+		t:    devspace.NewTracker(p, s, devspace.WithFlushPolicy(opts.FlushPolicy)),
+		opts: opts,
+		due:  make(chan struct{}, 1),
+	}
+}
+
+// Listen listens on the Unix socket at path. The socket left behind by an agent that didn't shut down cleanly
+// is replaced, so the caller must make sure no other agent is running.
+func Listen(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to remove stale socket")
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the user running the agent can send events to it.
+	if err := os.Chmod(path, 0o600); err != nil {
+		//nolint:errcheck // Why: The chmod error is the one returned.
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Serve accepts the events on l and flushes them every flush interval, or when the flush policy is met,
+// until ctx is done.
+// The listener is closed, and the events left are flushed before it returns.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	// The flush loop and the listener are stopped when accepting fails too.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		<-ctx.Done()
+		//nolint:errcheck // Why: Closing the listener only stops the accept loop.
+		l.Close()
+	}()
+
+	s.wg.Add(1)
+	go s.flushLoop(ctx)
+
+	var err error
+	for {
+		conn, aerr := l.Accept()
+		if aerr != nil {
+			if ctx.Err() == nil {
+				err = aerr
+			}
+			break
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(ctx, conn)
+		}()
+	}
+	cancel()
+	s.wg.Wait()
+
+	// The context is done, the last flush gets a fresh one.
+	timeout := s.opts.FlushTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownFlushTimeout
+	}
+	fctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if ferr := s.flush(fctx); err == nil {
+		err = ferr
+	}
+
+	return err
+}
+
+// flushLoop flushes the events every flush interval, and when woken up, until ctx is done.
+func (s *Server) flushLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.due:
+		}
+
+		//nolint:errcheck // Why: The events are sent by the next flush.
+		s.flush(ctx)
+	}
+}
+
+// flush sends the tracked events, unless another process is flushing them.
+func (s *Server) flush(ctx context.Context) error {
+	if s.opts.LockFlush != nil {
+		unlock, ok, err := s.opts.LockFlush()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		defer unlock()
+	}
+
+	if s.opts.FlushTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.FlushTimeout)
+		defer cancel()
+	}
+
+	// The tracker serializes the access to the store, the events are tracked while they're sent.
+	return s.t.Flush(ctx)
+}
+
+// handle tracks the event sent over conn, and acknowledges it.
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	ctx = trace.StartCall(ctx, "ingest.handle")
+	defer trace.EndCall(ctx)
+	defer conn.Close()
+
+	//nolint:errcheck // Why: Without the deadline the exchange fails only when the client gives up.
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	var req request
+	var resp response
+	err := json.NewDecoder(conn).Decode(&req)
+	switch {
+	case err != nil:
+		resp.Error = err.Error()
+	case req.Event == nil:
+		err = errors.New("missing event")
+		resp.Error = err.Error()
+	default:
+		trace.AddInfo(ctx, log.F{"ingest.hook": req.Event.Hook})
+		// The client tracks the event itself when the agent fails to.
+		if err = s.track(ctx, &req); err != nil {
+			resp.Error = err.Error()
+		}
+	}
+
+	if eerr := json.NewEncoder(conn).Encode(resp); eerr != nil && err == nil {
+		err = eerr
+	}
+
+	//nolint:errcheck // Why: There's nothing else to do with the error than to trace it.
+	trace.SetCallStatus(ctx, err)
+}
+
+// track tracks the event with the fields of the request, which are of the client that sent it. The flush loop
+// is woken up when the flush policy is met, so that the client doesn't wait on the flush.
+func (s *Server) track(ctx context.Context, req *request) error {
+	if err := s.t.TrackWithFields(ctx, req.Event, req.Fields); err != nil {
+		return err
+	}
+
+	if s.t.ShouldFlush(ctx, req.Event) {
+		select {
+		case s.due <- struct{}{}:
+		default:
+			// The flush loop is already woken up.
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+	"github.com/getoutreach/devtel/internal/store"
+)
+
+// recordingProcessor records the processed events as maps.
+type recordingProcessor struct {
+	mu     sync.Mutex
+	events []map[string]interface{}
+}
+
+func (p *recordingProcessor) ProcessRecords(ctx context.Context, events []interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, e := range events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			return err
+		}
+		p.events = append(p.events, m)
+	}
+	return nil
+}
+
+func (p *recordingProcessor) processed() []map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.events
+}
+
+// serve starts a server listening on a socket in a temporary dir. The returned function stops it.
+func serve(t *testing.T, p devspace.Processor, opts Options) (socket string, stop func() error) {
+	return serveStore(t, store.NewMemory(), p, opts)
+}
+
+// serveStore starts a server tracking the events in s, like serve.
+func serveStore(t *testing.T, s store.Store, p devspace.Processor, opts Options) (socket string, stop func() error) {
+	socket = filepath.Join(t.TempDir(), ".agent.sock")
+	l, err := Listen(socket)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(s, p, opts).Serve(ctx, l)
+	}()
+
+	return socket, func() error {
+		cancel()
+		return <-done
+	}
+}
+
+func TestServerTracksEvents(t *testing.T) {
+	ctx := context.Background()
+	p := &recordingProcessor{}
+	socket, stop := serve(t, p, Options{FlushInterval: time.Hour})
+
+	fields := map[string]interface{}{"branch": "main"}
+	assert.NoError(t, Send(ctx, socket, &devspace.Event{Hook: "before:deploy", ExecutionID: "1", Timestamp: 100}, fields))
+	assert.NoError(t, Send(ctx, socket, &devspace.Event{Hook: "after:deploy", ExecutionID: "1", Timestamp: 250}, fields))
+	assert.Empty(t, p.processed())
+
+	// The events left are flushed when the server stops.
+	assert.NoError(t, stop())
+	events := p.processed()
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "before:deploy", events[0]["hook"])
+		assert.Equal(t, "after:deploy", events[1]["hook"])
+		assert.EqualValues(t, 150, events[1]["duration_ms"])
+		assert.Equal(t, "main", events[1]["branch"])
+	}
+}
+
+func TestServerAddsFieldsToTheirEventOnly(t *testing.T) {
+	ctx := context.Background()
+	p := &recordingProcessor{}
+	socket, stop := serve(t, p, Options{FlushInterval: time.Hour})
+
+	fields := map[string]interface{}{"branch": "main"}
+	assert.NoError(t, Send(ctx, socket, &devspace.Event{Hook: "before:deploy", ExecutionID: "1", Timestamp: 100}, fields))
+	assert.NoError(t, Send(ctx, socket, &devspace.Event{Hook: "before:build", ExecutionID: "2", Timestamp: 200}, nil))
+
+	assert.NoError(t, stop())
+	events := p.processed()
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "main", events[0]["branch"])
+		assert.NotContains(t, events[1], "branch")
+	}
+}
+
+// blockingProcessor blocks processing the events until it's released.
+type blockingProcessor struct {
+	recordingProcessor
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingProcessor) ProcessRecords(ctx context.Context, events []interface{}) error {
+	select {
+	case p.started <- struct{}{}:
+	default:
+	}
+	<-p.release
+
+	return p.recordingProcessor.ProcessRecords(ctx, events)
+}
+
+func TestServerTracksWhileFlushing(t *testing.T) {
+	ctx := context.Background()
+	p := &blockingProcessor{started: make(chan struct{}, 1), release: make(chan struct{})}
+	socket, stop := serve(t, p, Options{FlushInterval: 10 * time.Millisecond})
+
+	assert.NoError(t, Send(ctx, socket, &devspace.Event{Hook: "before:build", ExecutionID: "1", Timestamp: 100}, nil))
+	<-p.started
+
+	// The flush is stuck on the upload, the event is tracked regardless.
+	sctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	assert.NoError(t, Send(sctx, socket, &devspace.Event{Hook: "before:build", ExecutionID: "2", Timestamp: 200}, nil))
+
+	close(p.release)
+	assert.NoError(t, stop())
+	assert.Len(t, p.processed(), 2)
+}
+
+func TestServerFlushesPeriodically(t *testing.T) {
+	p := &recordingProcessor{}
+	socket, stop := serve(t, p, Options{FlushInterval: 10 * time.Millisecond})
+	defer stop() //nolint:errcheck // Why: The events are already flushed.
+
+	assert.NoError(t, Send(context.Background(), socket, &devspace.Event{Hook: "before:build", Timestamp: 100}, nil))
+	assert.Eventually(t, func() bool { return len(p.processed()) == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestServerFlushesWhenPolicyIsMet(t *testing.T) {
+	ctx := context.Background()
+	p := &recordingProcessor{}
+	policy := devspace.FlushPolicy{Hooks: []string{"devCommand:after:execute"}}
+	socket, stop := serve(t, p, Options{FlushInterval: time.Hour, FlushPolicy: policy})
+	defer stop() //nolint:errcheck // Why: The events are already flushed.
+
+	assert.NoError(t, Send(ctx, socket, &devspace.Event{Hook: "devCommand:before:execute", Timestamp: 100}, nil))
+	assert.NoError(t, Send(ctx, socket, &devspace.Event{Hook: "devCommand:after:execute", Timestamp: 200}, nil))
+	assert.Eventually(t, func() bool { return len(p.processed()) == 2 }, time.Second, 10*time.Millisecond)
+}
+
+// failingStore fails to append the events.
+type failingStore struct {
+	store.Store
+}
+
+func (failingStore) Append(context.Context, store.IndexMarshaller) error {
+	return errors.New("disk full")
+}
+
+func TestServerReportsTrackingErrors(t *testing.T) {
+	socket, stop := serveStore(t, failingStore{store.NewMemory()}, &recordingProcessor{}, Options{FlushInterval: time.Hour})
+
+	// The hook tracks the event itself when the agent fails to.
+	err := Send(context.Background(), socket, &devspace.Event{Hook: "before:build", Timestamp: 100}, nil)
+	assert.EqualError(t, err, "disk full")
+	assert.NoError(t, stop())
+}
+
+func TestServerSkipsFlushWhileLocked(t *testing.T) {
+	p := &recordingProcessor{}
+	lockFlush := func() (func(), bool, error) { return nil, false, nil }
+	socket, stop := serve(t, p, Options{FlushInterval: time.Millisecond, LockFlush: lockFlush})
+
+	assert.NoError(t, Send(context.Background(), socket, &devspace.Event{Hook: "before:build", Timestamp: 100}, nil))
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, stop())
+	assert.Empty(t, p.processed())
+}
+
+func TestSendWithoutAgent(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), ".agent.sock")
+	err := Send(context.Background(), socket, &devspace.Event{Hook: "before:build"}, nil)
+	assert.Error(t, err)
+}
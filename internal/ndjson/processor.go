@@ -0,0 +1,161 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the package documentation and the Processor archiving the events to files.
+
+// Package ndjson contains the processor archiving the events locally, as newline-delimited JSON files.
+package ndjson
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/getoutreach/gobox/pkg/log"
+	"github.com/getoutreach/gobox/pkg/trace"
+	"github.com/pkg/errors"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+)
+
+// Options configure a Processor.
+type Options struct {
+	// Dir is the directory the files are written to. Defaults to DefaultDir.
+	Dir string
+	// Gzip compresses the files. Each flush appends a gzip member, which the gzip readers read as one stream.
+	Gzip bool
+}
+
+// DefaultDir returns the directory the events are archived to by default, .devtel/events in the home directory.
+func DefaultDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.TempDir()
+	}
+
+	return filepath.Join(home, ".devtel", "events")
+}
+
+// Processor appends the events to a file per day, named after the date of the events, e.g. 2022-05-01.ndjson.
+// The events without timestamp are archived on the day they're flushed.
+type Processor struct {
+	opts Options
+	now  func() time.Time
+}
+
+// NewProcessor returns a new Processor.
+func NewProcessor(opts Options) *Processor {
+	if opts.Dir == "" {
+		opts.Dir = DefaultDir()
+	}
+
+	return &Processor{
+		opts: opts,
+		now:  time.Now,
+	}
+}
+
+// ProcessRecords appends the events to the files.
+func (p *Processor) ProcessRecords(ctx context.Context, events []interface{}) error {
+	_, err := p.ProcessBatch(ctx, events)
+	return err
+}
+
+// ProcessBatch appends the events to the files, and returns the outcome of each event. The events that can't be
+// encoded are rejected, the events of the files that couldn't be written are retryable.
+func (p *Processor) ProcessBatch(ctx context.Context, events []interface{}) (devspace.Result, error) {
+	ctx = trace.StartCall(ctx, "ndjson.ProcessBatch")
+	defer trace.EndCall(ctx)
+
+	result := devspace.NewResult(len(events), devspace.Accepted)
+
+	// The lines and the indexes of the events, by file name.
+	lines := make(map[string][]byte)
+	indexes := make(map[string][]int)
+	for i, e := range events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			result[i] = devspace.Rejected
+			continue
+		}
+
+		name := p.fileName(b)
+		lines[name] = append(append(lines[name], b...), '\n')
+		indexes[name] = append(indexes[name], i)
+	}
+
+	names := make([]string, 0, len(lines))
+	for name := range lines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var err error
+	for _, name := range names {
+		if werr := p.write(name, lines[name]); werr != nil {
+			for _, i := range indexes[name] {
+				result[i] = devspace.Retryable
+			}
+			if err == nil {
+				err = errors.Wrapf(werr, "failed to write %s", name)
+			}
+		}
+	}
+	trace.AddInfo(ctx, log.F{"ndjson.files": len(names)})
+
+	return result, trace.SetCallStatus(ctx, err)
+}
+
+// fileName returns the name of the file the encoded event is archived in.
+func (p *Processor) fileName(event []byte) string {
+	var e struct {
+		Timestamp int64 `json:"timestamp"`
+	}
+
+	t := p.now()
+	if err := json.Unmarshal(event, &e); err == nil && e.Timestamp > 0 {
+		t = time.UnixMilli(e.Timestamp)
+	}
+
+	name := t.Format("2006-01-02") + ".ndjson"
+	if p.opts.Gzip {
+		name += ".gz"
+	}
+	return name
+}
+
+// write appends the lines to the named file in a single write, so that a failed write doesn't leave
+// a partial batch behind.
+func (p *Processor) write(name string, lines []byte) error {
+	if p.opts.Gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(lines); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		lines = buf.Bytes()
+	}
+
+	if err := os.MkdirAll(p.opts.Dir, 0o755); err != nil {
+		return err
+	}
+
+	// The events might contain personal details, the files are readable only by the user.
+	f, err := os.OpenFile(filepath.Join(p.opts.Dir, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(lines); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
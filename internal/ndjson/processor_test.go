@@ -0,0 +1,90 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package ndjson
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+)
+
+func TestProcessorWritesDailyFiles(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2022, 5, 3, 12, 0, 0, 0, time.Local)
+	p := NewProcessor(Options{Dir: dir})
+	p.now = func() time.Time { return now }
+
+	first := time.Date(2022, 5, 1, 10, 0, 0, 0, time.Local).UnixMilli()
+	second := time.Date(2022, 5, 2, 10, 0, 0, 0, time.Local).UnixMilli()
+	events := []interface{}{
+		map[string]interface{}{"hook": "before:deploy", "timestamp": first},
+		map[string]interface{}{"hook": "after:deploy", "timestamp": second},
+		map[string]interface{}{"hook": "before:build"},
+		func() {},
+	}
+
+	result, err := p.ProcessBatch(context.Background(), events)
+	assert.NoError(t, err)
+	assert.Equal(t, devspace.Result{devspace.Accepted, devspace.Accepted, devspace.Accepted, devspace.Rejected}, result)
+
+	// The next flush appends to the files.
+	assert.NoError(t, p.ProcessRecords(context.Background(), events[:1]))
+
+	b, err := os.ReadFile(filepath.Join(dir, "2022-05-01.ndjson"))
+	assert.NoError(t, err)
+	line := `{"hook":"before:deploy","timestamp":` + strconv.FormatInt(first, 10) + "}\n"
+	assert.Equal(t, line+line, string(b))
+
+	b, err = os.ReadFile(filepath.Join(dir, "2022-05-02.ndjson"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"hook":"after:deploy","timestamp":`+strconv.FormatInt(second, 10)+"}\n", string(b))
+
+	b, err = os.ReadFile(filepath.Join(dir, "2022-05-03.ndjson"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"hook":"before:build"}`+"\n", string(b))
+
+	info, err := os.Stat(filepath.Join(dir, "2022-05-03.ndjson"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestProcessorCompressesFiles(t *testing.T) {
+	dir := t.TempDir()
+	p := NewProcessor(Options{Dir: dir, Gzip: true})
+	p.now = func() time.Time { return time.Date(2022, 5, 1, 12, 0, 0, 0, time.Local) }
+
+	events := []interface{}{map[string]interface{}{"hook": "before:build"}}
+	assert.NoError(t, p.ProcessRecords(context.Background(), events))
+	assert.NoError(t, p.ProcessRecords(context.Background(), events))
+
+	// The members appended by each flush read as one stream.
+	f, err := os.Open(filepath.Join(dir, "2022-05-01.ndjson.gz"))
+	assert.NoError(t, err)
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	b, err := io.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"hook\":\"before:build\"}\n{\"hook\":\"before:build\"}\n", string(b))
+}
+
+func TestProcessorRetriesUnwritableFiles(t *testing.T) {
+	// The directory can't be created under a file.
+	file := filepath.Join(t.TempDir(), "file")
+	assert.NoError(t, os.WriteFile(file, nil, 0o600))
+
+	p := NewProcessor(Options{Dir: filepath.Join(file, "events")})
+	result, err := p.ProcessBatch(context.Background(), []interface{}{map[string]interface{}{"hook": "before:build"}})
+	assert.Error(t, err)
+	assert.Equal(t, devspace.Result{devspace.Retryable}, result)
+}
@@ -0,0 +1,190 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the package documentation and the Processor exporting the spans.
+
+// Package otlp contains the processor exporting the devspace hooks matched with their before hooks as
+// OpenTelemetry spans, over OTLP/HTTP.
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/getoutreach/gobox/pkg/log"
+	"github.com/getoutreach/gobox/pkg/trace"
+	"github.com/pkg/errors"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+	"github.com/getoutreach/devtel/internal/httpsend"
+)
+
+// DefaultEndpoint is the OTLP/HTTP endpoint of a collector running locally.
+const DefaultEndpoint = "http://localhost:4318"
+
+// tracesPath is the path of the traces export, relative to the endpoint.
+const tracesPath = "/v1/traces"
+
+// Option configures a Processor.
+type Option func(*Processor)
+
+// WithEndpoint sets the OTLP/HTTP endpoint of the collector, the traces are exported to its /v1/traces path.
+// Defaults to DefaultEndpoint.
+func WithEndpoint(endpoint string) Option {
+	return func(p *Processor) {
+		p.sender.URL = strings.TrimSuffix(endpoint, "/") + tracesPath
+	}
+}
+
+// WithTracesURL sets the full URL the traces are exported to.
+func WithTracesURL(url string) Option {
+	return func(p *Processor) {
+		p.sender.URL = url
+	}
+}
+
+// WithHeader adds a header to the export requests.
+func WithHeader(key, value string) Option {
+	return func(p *Processor) {
+		p.sender.Header.Add(key, value)
+	}
+}
+
+// WithHTTPClient sets the HTTP client the spans are exported with. The client gets httpsend.DefaultTimeout
+// when it has no timeout.
+func WithHTTPClient(client *http.Client) Option {
+	return func(p *Processor) {
+		p.sender.Client = client
+	}
+}
+
+// envOptions returns the options set by the standard OpenTelemetry environment variables:
+// OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, OTEL_EXPORTER_OTLP_HEADERS
+// and OTEL_EXPORTER_OTLP_TRACES_HEADERS.
+func envOptions() []Option {
+	var opts []Option
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		opts = append(opts, WithEndpoint(endpoint))
+	}
+	if url := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); url != "" {
+		opts = append(opts, WithTracesURL(url))
+	}
+
+	for _, env := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_TRACES_HEADERS"} {
+		for k, values := range httpsend.ParseHeaders(os.Getenv(env)) {
+			for _, v := range values {
+				opts = append(opts, WithHeader(k, v))
+			}
+		}
+	}
+
+	return opts
+}
+
+// Processor exports the events matched with their before hooks as spans. The span of such an event starts at its
+// before hook, and ends with it. The execution ID is the trace ID, the hook is the span name, and the error is
+// the span status. The other events are not exported. The failed exports are not retried, the spans are
+// retryable until the next flush.
+type Processor struct {
+	serviceName string
+	sender      httpsend.Sender
+}
+
+// New returns a new Processor exporting the spans of the service, configured by the options.
+func New(serviceName string, opts ...Option) *Processor {
+	p := &Processor{
+		serviceName: serviceName,
+		sender: httpsend.Sender{
+			Client: http.DefaultClient,
+			URL:    DefaultEndpoint + tracesPath,
+			Header: make(http.Header),
+		},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.sender.Client = httpsend.WithDefaultTimeout(p.sender.Client)
+	p.sender.Header.Set("Content-Type", "application/json")
+
+	return p
+}
+
+// NewProcessor returns a new Processor exporting the spans of the service, configured by the environment variables.
+func NewProcessor(serviceName string) *Processor {
+	return New(serviceName, envOptions()...)
+}
+
+// ProcessRecords exports the spans of the given events.
+func (p *Processor) ProcessRecords(ctx context.Context, events []interface{}) error {
+	_, err := p.ProcessBatch(ctx, events)
+	return err
+}
+
+// ProcessBatch exports the spans of the given events, and returns the outcome of each event. The events
+// that are not spans are accepted, there's nothing to export for them.
+func (p *Processor) ProcessBatch(ctx context.Context, events []interface{}) (devspace.Result, error) {
+	ctx = trace.StartCall(ctx, "otlp.ProcessBatch")
+	defer trace.EndCall(ctx)
+
+	result := devspace.NewResult(len(events), devspace.Accepted)
+
+	var spans []span
+	var exported []int
+	for i, e := range events {
+		s, ok, err := newSpan(e)
+		if err != nil {
+			result[i] = devspace.Rejected
+			continue
+		}
+		if ok {
+			spans = append(spans, s)
+			exported = append(exported, i)
+		}
+	}
+	trace.AddInfo(ctx, log.F{"otlp.spans": len(spans)})
+
+	if len(spans) == 0 {
+		return result, nil
+	}
+
+	rejected, err := p.export(ctx, spans)
+	if err != nil {
+		outcome := devspace.Retryable
+		if rejected {
+			outcome = devspace.Rejected
+		}
+		for _, i := range exported {
+			result[i] = outcome
+		}
+	}
+
+	return result, trace.SetCallStatus(ctx, err)
+}
+
+// export sends the spans to the collector. On failure, it returns whether the collector refused the spans
+// themselves, so that sending them again would fail the same way.
+func (p *Processor) export(ctx context.Context, spans []span) (rejected bool, err error) {
+	body, err := json.Marshal(exportRequest{
+		ResourceSpans: []resourceSpans{{
+			Resource: resource{
+				Attributes: []keyValue{{Key: "service.name", Value: anyValue{StringValue: &p.serviceName}}},
+			},
+			ScopeSpans: []scopeSpans{{
+				Scope: scope{Name: p.serviceName},
+				Spans: spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return true, errors.Wrap(err, "failed to encode spans")
+	}
+
+	if err := p.sender.Post(ctx, body); err != nil {
+		var se *httpsend.StatusError
+		return errors.As(err, &se) && se.Rejected(), err
+	}
+
+	return false, nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+	"github.com/getoutreach/devtel/internal/httpsend"
+	"github.com/getoutreach/devtel/internal/store"
+)
+
+// fakeCollector records the spans exported to it, and responds with the status code.
+type fakeCollector struct {
+	statusCode int
+	spans      []span
+	headers    http.Header
+}
+
+func (c *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != tracesPath {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c.headers = r.Header
+
+	var req exportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+
+	w.WriteHeader(c.statusCode)
+}
+
+func events() []interface{} {
+	return []interface{}{
+		map[string]interface{}{
+			"hook":         "before:deploy",
+			"execution_id": "9714f00a-b998-49e7-97a9-a8e2051905f7",
+			"timestamp":    1651388142703,
+		},
+		map[string]interface{}{
+			"hook":         "after:deploy",
+			"execution_id": "9714f00a-b998-49e7-97a9-a8e2051905f7",
+			"timestamp":    1651388151749,
+			"duration_ms":  9046,
+			"command":      map[string]interface{}{"name": "deploy", "flags": []string{"--no-warn"}},
+		},
+		map[string]interface{}{
+			"hook":         "error:build",
+			"execution_id": "9714f00a-b998-49e7-97a9-a8e2051905f7",
+			"error":        "image build failed",
+			"timestamp":    1651388152000,
+			"duration_ms":  100,
+		},
+	}
+}
+
+func TestProcessorExportsMatchedEvents(t *testing.T) {
+	collector := &fakeCollector{statusCode: http.StatusOK}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	p := New("devtel", WithEndpoint(server.URL), WithHTTPClient(server.Client()), WithHeader("X-Team", "dev"))
+	result, err := p.ProcessBatch(context.Background(), events())
+	assert.NoError(t, err)
+	assert.Equal(t, devspace.NewResult(3, devspace.Accepted), result)
+	assert.Equal(t, "dev", collector.headers.Get("X-Team"))
+
+	if assert.Len(t, collector.spans, 2) {
+		s := collector.spans[0]
+		assert.Equal(t, "9714f00ab99849e797a9a8e2051905f7", s.TraceID)
+		assert.Len(t, s.SpanID, 16)
+		assert.Equal(t, "after:deploy", s.Name)
+		assert.Equal(t, "1651388142703000000", s.StartTimeUnixNano)
+		assert.Equal(t, "1651388151749000000", s.EndTimeUnixNano)
+		assert.Equal(t, status{}, s.Status)
+
+		attrs := map[string]anyValue{}
+		for _, kv := range s.Attributes {
+			attrs[kv.Key] = kv.Value
+		}
+		assert.Equal(t, "deploy", *attrs["command.name"].StringValue)
+		assert.Len(t, attrs["command.flags"].ArrayValue.Values, 1)
+		assert.NotContains(t, attrs, "duration_ms")
+
+		s = collector.spans[1]
+		assert.Equal(t, collector.spans[0].TraceID, s.TraceID)
+		assert.NotEqual(t, collector.spans[0].SpanID, s.SpanID)
+		assert.Equal(t, status{Code: statusCodeError, Message: "image build failed"}, s.Status)
+	}
+}
+
+func TestProcessorSkipsUnmatchedEvents(t *testing.T) {
+	collector := &fakeCollector{statusCode: http.StatusOK}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	// The after hook is tracked without its before hook, there's no span to export.
+	s := store.NewMemory()
+	tracker := devspace.NewTracker(New("devtel", WithEndpoint(server.URL), WithHTTPClient(server.Client())), s)
+	tracker.Track(context.Background(), &devspace.Event{Hook: "after:deploy", ExecutionID: "1", Timestamp: 1651388151749})
+	assert.NoError(t, tracker.Flush(context.Background()))
+	assert.Empty(t, collector.spans)
+}
+
+func TestProcessorOutcomes(t *testing.T) {
+	collector := &fakeCollector{statusCode: http.StatusServiceUnavailable}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	p := New("devtel", WithTracesURL(server.URL+tracesPath), WithHTTPClient(server.Client()))
+	result, err := p.ProcessBatch(context.Background(), events())
+	assert.Error(t, err)
+	assert.Equal(t, devspace.Result{devspace.Accepted, devspace.Retryable, devspace.Retryable}, result)
+
+	collector.statusCode = http.StatusBadRequest
+	result, err = p.ProcessBatch(context.Background(), events())
+	assert.Error(t, err)
+	assert.Equal(t, devspace.Result{devspace.Accepted, devspace.Rejected, devspace.Rejected}, result)
+
+	// Nothing is exported without matched events.
+	collector.spans = nil
+	result, err = p.ProcessBatch(context.Background(), events()[:1])
+	assert.NoError(t, err)
+	assert.Equal(t, devspace.Result{devspace.Accepted}, result)
+	assert.Empty(t, collector.spans)
+}
+
+func TestTraceID(t *testing.T) {
+	assert.Equal(t, "9714f00ab99849e797a9a8e2051905f7", traceID("9714F00A-B998-49E7-97A9-A8E2051905F7"))
+	assert.Len(t, traceID("not-a-uuid"), 32)
+	assert.Equal(t, traceID("not-a-uuid"), traceID("not-a-uuid"))
+}
+
+func TestEnvOptions(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "api-key=secret, x-team = dev,malformed")
+
+	p := NewProcessor("devtel")
+	assert.Equal(t, "http://collector:4318/v1/traces", p.sender.URL)
+	assert.Equal(t, "secret", p.sender.Header.Get("api-key"))
+	assert.Equal(t, "dev", p.sender.Header.Get("x-team"))
+	assert.Equal(t, httpsend.DefaultTimeout, p.sender.Client.Timeout)
+
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "http://traces:4318/custom")
+	assert.Equal(t, "http://traces:4318/custom", NewProcessor("devtel").sender.URL)
+}
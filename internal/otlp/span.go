@@ -0,0 +1,206 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the conversion of the matched events into OTLP spans.
+
+package otlp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+)
+
+// The OTLP/JSON encoding of the export request. The IDs are hex encoded, the 64-bit integers are strings.
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+
+	scopeSpans struct {
+		Scope scope  `json:"scope"`
+		Spans []span `json:"spans"`
+	}
+
+	scope struct {
+		Name string `json:"name"`
+	}
+
+	span struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes,omitempty"`
+		Status            status     `json:"status"`
+	}
+
+	status struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+
+	keyValue struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+
+	anyValue struct {
+		StringValue *string     `json:"stringValue,omitempty"`
+		BoolValue   *bool       `json:"boolValue,omitempty"`
+		IntValue    *string     `json:"intValue,omitempty"`
+		DoubleValue *float64    `json:"doubleValue,omitempty"`
+		ArrayValue  *arrayValue `json:"arrayValue,omitempty"`
+	}
+
+	arrayValue struct {
+		Values []anyValue `json:"values"`
+	}
+)
+
+const (
+	// spanKindInternal is the kind of the spans, the hooks are steps of a devspace command.
+	spanKindInternal = 1
+	// statusCodeError marks the spans of the failed steps.
+	statusCodeError = 2
+)
+
+// timingFields are the event fields the span times are built from, they're not added as attributes.
+var timingFields = map[string]bool{
+	"timestamp":   true,
+	"@timestamp":  true,
+	"duration_ms": true,
+}
+
+// newSpan builds the span of an event matched with its before hook. It returns false if the event was not
+// matched, as it has no duration then.
+func newSpan(event interface{}) (span, bool, error) {
+	data, err := devspace.Fields(event)
+	if err != nil {
+		return span{}, false, err
+	}
+
+	hook := data.String("hook")
+	end := data.Int("timestamp")
+	duration := data.Int("duration_ms")
+	if hook == "" || duration <= 0 {
+		return span{}, false, nil
+	}
+
+	executionID := data.String("execution_id")
+	s := span{
+		TraceID:           traceID(executionID),
+		SpanID:            spanID(executionID, hook),
+		Name:              hook,
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: unixNano(end - duration),
+		EndTimeUnixNano:   unixNano(end),
+		Attributes:        attributes(data),
+	}
+
+	if msg := data.String("error"); msg != "" || strings.HasPrefix(hook, "error:") || strings.Contains(hook, ":error") {
+		s.Status = status{Code: statusCodeError, Message: msg}
+	}
+
+	return s, true, nil
+}
+
+// traceID returns the trace ID of the execution. The devspace execution IDs are UUIDs, which are used as they are.
+// Other IDs are hashed.
+func traceID(executionID string) string {
+	if id := strings.ReplaceAll(executionID, "-", ""); len(id) == 32 {
+		if _, err := hex.DecodeString(id); err == nil {
+			return strings.ToLower(id)
+		}
+	}
+
+	sum := sha256.Sum256([]byte(executionID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// spanID returns the span ID of the hook within the execution.
+func spanID(executionID, hook string) string {
+	sum := sha256.Sum256([]byte(executionID + "_" + hook))
+	return hex.EncodeToString(sum[:8])
+}
+
+// unixNano converts unix milliseconds into unix nanoseconds.
+func unixNano(ms int64) string {
+	return strconv.FormatInt(ms*1e6, 10)
+}
+
+// attributes returns the event fields as span attributes. Nested fields are addressed by dotted path.
+func attributes(data map[string]interface{}) []keyValue {
+	var attrs []keyValue
+
+	var add func(prefix string, data map[string]interface{})
+	add = func(prefix string, data map[string]interface{}) {
+		for k, v := range data {
+			if prefix == "" && timingFields[k] {
+				continue
+			}
+
+			if nested, ok := v.(map[string]interface{}); ok {
+				add(prefix+k+".", nested)
+				continue
+			}
+			if value, ok := newValue(v); ok {
+				attrs = append(attrs, keyValue{Key: prefix + k, Value: value})
+			}
+		}
+	}
+	add("", data)
+
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return attrs
+}
+
+// newValue converts a decoded JSON value into an attribute value. It returns false for null values.
+func newValue(v interface{}) (anyValue, bool) {
+	switch v := v.(type) {
+	case string:
+		return anyValue{StringValue: &v}, true
+	case bool:
+		return anyValue{BoolValue: &v}, true
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			i := strconv.FormatInt(int64(v), 10)
+			return anyValue{IntValue: &i}, true
+		}
+		return anyValue{DoubleValue: &v}, true
+	case []interface{}:
+		values := make([]anyValue, 0, len(v))
+		for _, item := range v {
+			if value, ok := newValue(item); ok {
+				values = append(values, value)
+			}
+		}
+		return anyValue{ArrayValue: &arrayValue{Values: values}}, true
+	case map[string]interface{}:
+		// Maps nested in arrays are kept as JSON.
+		b, err := json.Marshal(v)
+		if err != nil {
+			return anyValue{}, false
+		}
+		s := string(b)
+		return anyValue{StringValue: &s}, true
+	default:
+		return anyValue{}, false
+	}
+}
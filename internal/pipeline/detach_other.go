@@ -0,0 +1,13 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains no-op detaching of child processes for other systems.
+
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package pipeline
+
+import "os/exec"
+
+// detach is a no-op, we only release for linux and darwin.
+func detach(_ *exec.Cmd) {}
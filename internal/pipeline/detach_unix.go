@@ -0,0 +1,18 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains detaching of child processes for unix systems.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package pipeline
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detach starts the process in a new session, so that it's not killed with the terminal of devspace.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
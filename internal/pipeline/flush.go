@@ -0,0 +1,40 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the handoff of flushing to a detached process.
+
+package pipeline
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/getoutreach/devtel/internal/store"
+)
+
+// flushLockName is the name of the lock file held by the process flushing the events, in the log dir.
+const flushLockName = ".flush.lock"
+
+// SpawnFlush starts a detached devtel flush process configured with the same flags, so that the caller doesn't wait
+// on the upload. The process outlives the caller, its output is discarded.
+func (p *Pipeline) SpawnFlush() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(exe, append([]string{"--skip-update", "flush"}, p.flagArgs...)...)
+	cmd.Env = os.Environ()
+	detach(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// LockFlush acquires the single-flusher lock without waiting. It returns false if another process is flushing,
+// otherwise the returned function releases the lock.
+func (p *Pipeline) LockFlush() (func(), bool, error) {
+	return store.TryLockFile(filepath.Join(p.LogDir, flushLockName))
+}
@@ -0,0 +1,201 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the package documentation and the wiring of the store and the processor.
+
+// Package pipeline wires the store and the processor the devtel commands track and flush the events with.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+	"github.com/getoutreach/devtel/internal/store"
+)
+
+// DefaultFlushTimeout bounds a flush, unless configured otherwise.
+const DefaultFlushTimeout = time.Minute
+
+// agentSocketName is the name of the socket the devtel agent listens on, in the default log dir.
+const agentSocketName = ".agent.sock"
+
+// Flags returns the flags configuring the store, the processor and when the events are flushed.
+func Flags() []cli.Flag {
+	policy := devspace.DefaultFlushPolicy()
+
+	return append(append(StoreFlags(),
+		&cli.StringSliceFlag{
+			Name:    "sink",
+			Usage:   "Destinations of the events, telefork, otlp, file, webhook or statsd. The events are sent to each of them",
+			Value:   cli.NewStringSlice(SinkTelefork),
+			EnvVars: []string{"DEVTEL_SINK"},
+		},
+		&cli.StringFlag{
+			Name:    "agent-socket",
+			Usage:   "Unix socket of the devtel agent. Defaults to .agent.sock in the default log dir",
+			EnvVars: []string{"DEVTEL_AGENT_SOCKET"},
+		},
+		&cli.IntFlag{
+			Name:    "flush-max-events",
+			Usage:   "Send the events once this many are waiting, 0 disables it",
+			Value:   policy.MaxEvents,
+			EnvVars: []string{"DEVTEL_FLUSH_MAX_EVENTS"},
+		},
+		&cli.DurationFlag{
+			Name:    "flush-max-age",
+			Usage:   "Send the events once the oldest waiting is older than this, 0 disables it",
+			Value:   policy.MaxAge,
+			EnvVars: []string{"DEVTEL_FLUSH_MAX_AGE"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "flush-hook",
+			Usage:   "Send the events after this hook",
+			Value:   cli.NewStringSlice(policy.Hooks...),
+			EnvVars: []string{"DEVTEL_FLUSH_HOOKS"},
+		},
+		&cli.DurationFlag{
+			Name:    "flush-timeout",
+			Usage:   "Give up sending the events after this long, the events left are sent by the next flush",
+			Value:   DefaultFlushTimeout,
+			EnvVars: []string{"DEVTEL_FLUSH_TIMEOUT"},
+		},
+	), sinkFlags()...)
+}
+
+// StoreFlags returns the flags configuring the store, for the commands that only need the store.
+func StoreFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "store",
+			Usage:   "Storage of the tracked events, jsonl, sqlite or memory",
+			Value:   string(store.BackendJSONL),
+			EnvVars: []string{"DEVTEL_STORE"},
+		},
+	}
+}
+
+// NewStore creates the store configured by the flags, with the rest of opts. The store is not initialized.
+func NewStore(c *cli.Context, opts *store.Options) (store.Store, error) {
+	opts.Backend = store.Backend(c.String("store"))
+	return store.NewStore(opts)
+}
+
+// Pipeline holds the store the events are tracked in, and the processor they're flushed to.
+type Pipeline struct {
+	Store     store.Store
+	Processor devspace.Processor
+	// FlushPolicy decides when the tracked events are flushed.
+	FlushPolicy devspace.FlushPolicy
+	// FlushTimeout bounds a flush, so that a stalled sink doesn't keep the flushing process, and the flush lock,
+	// forever. Zero disables it.
+	FlushTimeout time.Duration
+
+	// LogDir is the directory of the store, it's empty when the store is kept in memory.
+	LogDir string
+	// AgentSocket is the Unix socket the devtel agent listens on.
+	AgentSocket string
+	// flagArgs are the flags the pipeline was configured with, for the processes it spawns.
+	flagArgs []string
+}
+
+// New creates the store and the processor configured by the flags. The store is not initialized.
+func New(c *cli.Context, teleforkAPIKey string) (*Pipeline, error) {
+	opts := &store.Options{
+		CompactionThreshold: 1000,
+		MaxSegmentBytes:     1 << 20,
+		MaxSegmentAge:       24 * time.Hour,
+	}
+	s, err := NewStore(c, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := newProcessor(c, c.StringSlice("sink"), teleforkAPIKey, opts.LogDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// The socket doesn't depend on the store, so that the hooks find the agent whichever store they'd use.
+	socket := c.String("agent-socket")
+	if socket == "" {
+		socket = filepath.Join(store.DefaultLogDir(), agentSocketName)
+	}
+
+	return &Pipeline{
+		Store:     s,
+		Processor: p,
+		FlushPolicy: devspace.FlushPolicy{
+			MaxEvents: c.Int("flush-max-events"),
+			MaxAge:    c.Duration("flush-max-age"),
+			Hooks:     c.StringSlice("flush-hook"),
+		},
+		FlushTimeout: c.Duration("flush-timeout"),
+		LogDir:       opts.LogDir,
+		AgentSocket:  socket,
+		flagArgs:     flagArgs(c),
+	}, nil
+}
+
+// flagArgs returns the pipeline flags set on the command line or by the environment variables, as arguments
+// of another devtel command.
+func flagArgs(c *cli.Context) []string {
+	var args []string
+	for _, f := range Flags() {
+		name := f.Names()[0]
+		if !c.IsSet(name) {
+			continue
+		}
+
+		if _, ok := f.(*cli.StringSliceFlag); ok {
+			for _, v := range c.StringSlice(name) {
+				args = append(args, "--"+name, v)
+			}
+			continue
+		}
+		args = append(args, fmt.Sprintf("--%s=%v", name, c.Value(name)))
+	}
+
+	return args
+}
+
+// Persistent returns true if the tracked events outlive the process, so that another process can flush them.
+func (p *Pipeline) Persistent() bool {
+	return p.LogDir != ""
+}
+
+// LockAgent acquires the lock held by the running devtel agent without waiting. It returns false if another agent
+// is running, otherwise the returned function releases the lock.
+func (p *Pipeline) LockAgent() (func(), bool, error) {
+	return store.TryLockFile(p.AgentSocket + ".lock")
+}
+
+// FlushContext returns a context bounded by the flush timeout, for flushing the events.
+func (p *Pipeline) FlushContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.FlushTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, p.FlushTimeout)
+}
+
+// GC enforces the retention policy of the store, the stores kept in memory have none. It locks the store
+// exclusively, so it's left to the commands off the path of the hooks.
+func (p *Pipeline) GC(ctx context.Context) error {
+	if c, ok := p.Store.(store.Collector); ok {
+		_, err := c.GC(ctx, false)
+		return err
+	}
+	return nil
+}
+
+// Close releases the resources held by the store.
+func (p *Pipeline) Close() error {
+	if closer, ok := p.Store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
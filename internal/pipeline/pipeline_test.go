@@ -0,0 +1,119 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package pipeline
+
+import (
+	"context"
+	"flag"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+	"github.com/getoutreach/devtel/internal/store"
+)
+
+// newContext returns a cli context with the pipeline flags parsed from args.
+func newContext(t *testing.T, args ...string) *cli.Context {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range Flags() {
+		assert.NoError(t, f.Apply(set))
+	}
+	assert.NoError(t, set.Parse(args))
+
+	return cli.NewContext(&cli.App{Name: "devtel"}, set, nil)
+}
+
+func TestNewMemoryPipeline(t *testing.T) {
+	p, err := New(newContext(t, "--store", "memory"), "testKey")
+	assert.NoError(t, err)
+	assert.IsType(t, &store.MemoryStore{}, p.Store)
+	assert.False(t, p.Persistent())
+	assert.Equal(t, filepath.Join(store.DefaultLogDir(), ".agent.sock"), p.AgentSocket)
+	assert.NoError(t, p.Close())
+}
+
+func TestNewWithAgentSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	p, err := New(newContext(t, "--store", "memory", "--agent-socket", socket), "testKey")
+	assert.NoError(t, err)
+	assert.Equal(t, socket, p.AgentSocket)
+}
+
+func TestNewRejectsUnknownStore(t *testing.T) {
+	_, err := New(newContext(t, "--store", "csv"), "testKey")
+	assert.Error(t, err)
+}
+
+func TestNewWithSink(t *testing.T) {
+	p, err := New(newContext(t, "--store", "memory", "--sink", "otlp"), "testKey")
+	assert.NoError(t, err)
+	assert.IsType(t, &devspace.CircuitBreaker{}, p.Processor)
+
+	p, err = New(newContext(t, "--store", "memory", "--sink", "statsd"), "testKey")
+	assert.NoError(t, err)
+	assert.IsType(t, &devspace.CircuitBreaker{}, p.Processor)
+
+	_, err = New(newContext(t, "--store", "memory", "--sink", "kafka"), "testKey")
+	assert.Error(t, err)
+
+	p, err = New(newContext(t, "--store", "memory", "--sink", "telefork", "--sink", "otlp"), "testKey")
+	assert.NoError(t, err)
+	assert.IsType(t, &devspace.MultiProcessor{}, p.Processor)
+
+	// The webhook needs its URL.
+	t.Setenv("DEVTEL_WEBHOOK_URL", "")
+	_, err = New(newContext(t, "--store", "memory", "--sink", "webhook"), "testKey")
+	assert.Error(t, err)
+
+	t.Setenv("DEVTEL_WEBHOOK_URL", "http://localhost/events")
+	_, err = New(newContext(t, "--store", "memory", "--sink", "webhook"), "testKey")
+	assert.NoError(t, err)
+}
+
+func TestFlagArgs(t *testing.T) {
+	c := newContext(t, "--store", "sqlite", "--sink", "telefork", "--sink", "file", "--file-sink-gzip",
+		"--flush-max-age", "1m")
+	assert.Equal(t, []string{
+		"--store=sqlite", "--sink", "telefork", "--sink", "file", "--flush-max-age=1m0s", "--file-sink-gzip=true",
+	}, flagArgs(c))
+
+	// The flags are accepted back.
+	assert.Equal(t, flagArgs(c), flagArgs(newContext(t, flagArgs(c)...)))
+}
+
+func TestLockFlushAllowsSingleFlusher(t *testing.T) {
+	p := &Pipeline{LogDir: t.TempDir()}
+
+	unlock, ok, err := p.LockFlush()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	_, ok, err = p.LockFlush()
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	unlock()
+}
+
+func TestFlushContext(t *testing.T) {
+	p, err := New(newContext(t, "--store", "memory"), "testKey")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultFlushTimeout, p.FlushTimeout)
+
+	ctx, cancel := p.FlushContext(context.Background())
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(DefaultFlushTimeout), deadline, time.Second)
+
+	p, err = New(newContext(t, "--store", "memory", "--flush-timeout", "0"), "testKey")
+	assert.NoError(t, err)
+	ctx, cancel = p.FlushContext(context.Background())
+	defer cancel()
+	_, ok = ctx.Deadline()
+	assert.False(t, ok)
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the processors the events can be sent to.
+
+package pipeline
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+	"github.com/getoutreach/devtel/internal/ndjson"
+	"github.com/getoutreach/devtel/internal/otlp"
+	"github.com/getoutreach/devtel/internal/statsd"
+	"github.com/getoutreach/devtel/internal/telefork"
+	"github.com/getoutreach/devtel/internal/webhook"
+)
+
+// The sinks the events can be sent to.
+const (
+	// SinkTelefork sends the events to Telefork.
+	SinkTelefork = "telefork"
+	// SinkOTLP exports the matched events as spans to an OTLP/HTTP collector, configured by the standard
+	// OTEL_EXPORTER_OTLP_* environment variables.
+	SinkOTLP = "otlp"
+	// SinkFile archives the events to local newline-delimited JSON files, a file per day.
+	SinkFile = "file"
+	// SinkWebhook posts the events to an arbitrary URL, configured by the DEVTEL_WEBHOOK_* environment variables,
+	// so that the credentials don't show in the arguments of the flush processes.
+	SinkWebhook = "webhook"
+	// SinkStatsD emits metrics of the hooks to a StatsD or DogStatsD server, configured by the DEVTEL_STATSD_*
+	// environment variables, or the DD_AGENT_HOST and DD_DOGSTATSD_PORT of the Datadog libraries.
+	SinkStatsD = "statsd"
+)
+
+// sinkFlags returns the flags configuring the sinks.
+func sinkFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "file-sink-dir",
+			Usage:   "Directory the file sink archives the events to. Defaults to .devtel/events in the home directory",
+			EnvVars: []string{"DEVTEL_FILE_SINK_DIR"},
+		},
+		&cli.BoolFlag{
+			Name:    "file-sink-gzip",
+			Usage:   "Compress the files of the file sink with gzip",
+			EnvVars: []string{"DEVTEL_FILE_SINK_GZIP"},
+		},
+	}
+}
+
+// newProcessor creates the processor sending the events to the named sinks. The delivery to each sink is tracked
+// separately when there are more of them.
+func newProcessor(c *cli.Context, names []string, teleforkAPIKey, logDir string) (devspace.Processor, error) {
+	if len(names) == 0 {
+		names = []string{SinkTelefork}
+	}
+
+	sinks := make([]devspace.Sink, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		p, err := newSink(c, name, teleforkAPIKey, logDir)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, devspace.Sink{Name: name, Processor: p})
+	}
+
+	if len(sinks) == 1 {
+		return sinks[0].Processor, nil
+	}
+	return devspace.NewMultiProcessor(sinks...), nil
+}
+
+// newSink creates the processor of the named sink, wrapped in a circuit breaker. The state of the breaker
+// lives in logDir, so that it's shared by the hooks. It's kept in memory when logDir is empty.
+func newSink(c *cli.Context, name, teleforkAPIKey, logDir string) (devspace.Processor, error) {
+	var p devspace.Processor
+	switch name {
+	case SinkTelefork:
+		p = telefork.NewProcessor(c.App.Name, teleforkAPIKey)
+	case SinkOTLP:
+		p = otlp.NewProcessor(c.App.Name)
+	case SinkFile:
+		p = ndjson.NewProcessor(ndjson.Options{
+			Dir:  c.String("file-sink-dir"),
+			Gzip: c.Bool("file-sink-gzip"),
+		})
+	case SinkWebhook:
+		w, err := webhook.NewProcessor()
+		if err != nil {
+			return nil, err
+		}
+		p = w
+	case SinkStatsD:
+		p = statsd.NewProcessor()
+	default:
+		return nil, fmt.Errorf("unknown sink %q", name)
+	}
+
+	var breakerPath string
+	if logDir != "" {
+		breakerPath = filepath.Join(logDir, "."+name+".breaker")
+	}
+
+	return devspace.NewCircuitBreaker(p, devspace.BreakerOptions{StatePath: breakerPath}), nil
+}
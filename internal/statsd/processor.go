@@ -0,0 +1,240 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the package documentation and the Processor sending the metrics.
+
+// Package statsd contains the processor emitting metrics of the devspace hooks to a StatsD or DogStatsD server
+// over UDP, so that the dashboards don't depend on parsing the events.
+package statsd
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/getoutreach/gobox/pkg/log"
+	"github.com/getoutreach/gobox/pkg/trace"
+	"github.com/pkg/errors"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+)
+
+// DefaultAddr is the address of the DogStatsD server of a Datadog agent running locally.
+const DefaultAddr = "localhost:8125"
+
+// DefaultPrefix prefixes the names of the metrics unless configured otherwise.
+const DefaultPrefix = "devtel."
+
+// maxPacketSize is the size the metrics are packed into datagrams up to, so that they're not fragmented
+// on the usual networks.
+const maxPacketSize = 1432
+
+// Option configures a Processor.
+type Option func(*Processor)
+
+// WithAddr sets the UDP address of the server. Defaults to DefaultAddr.
+func WithAddr(addr string) Option {
+	return func(p *Processor) {
+		p.addr = addr
+	}
+}
+
+// WithPrefix sets the prefix of the metric names. Defaults to DefaultPrefix.
+func WithPrefix(prefix string) Option {
+	return func(p *Processor) {
+		p.prefix = prefix
+	}
+}
+
+// WithPlain sends plain StatsD metrics, without the DogStatsD tags.
+func WithPlain(plain bool) Option {
+	return func(p *Processor) {
+		p.plain = plain
+	}
+}
+
+// envOptions returns the options set by the environment variables: DEVTEL_STATSD_ADDR sets the address,
+// otherwise it's made of the DD_AGENT_HOST and DD_DOGSTATSD_PORT of the Datadog libraries. DEVTEL_STATSD_PREFIX
+// sets the prefix, and DEVTEL_STATSD_PLAIN drops the tags.
+func envOptions() []Option {
+	var opts []Option
+	if addr := os.Getenv("DEVTEL_STATSD_ADDR"); addr != "" {
+		opts = append(opts, WithAddr(addr))
+	} else if host, port := os.Getenv("DD_AGENT_HOST"), os.Getenv("DD_DOGSTATSD_PORT"); host != "" || port != "" {
+		if host == "" {
+			host = "localhost"
+		}
+		if port == "" {
+			port = "8125"
+		}
+		opts = append(opts, WithAddr(net.JoinHostPort(host, port)))
+	}
+
+	if prefix, ok := os.LookupEnv("DEVTEL_STATSD_PREFIX"); ok {
+		opts = append(opts, WithPrefix(prefix))
+	}
+
+	// Invalid values leave the tags on.
+	if plain, err := strconv.ParseBool(os.Getenv("DEVTEL_STATSD_PLAIN")); err == nil {
+		opts = append(opts, WithPlain(plain))
+	}
+
+	return opts
+}
+
+// Processor emits a hook.count counter for each hook, and a hook.duration timing for the hooks matched with their
+// before hook. The metrics are tagged by the hook, the command name, the devenv runtime and the status.
+type Processor struct {
+	addr   string
+	prefix string
+	plain  bool
+}
+
+// New returns a new Processor configured by the options.
+func New(opts ...Option) *Processor {
+	p := &Processor{
+		addr:   DefaultAddr,
+		prefix: DefaultPrefix,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// NewProcessor returns a new Processor configured by the environment variables.
+func NewProcessor() *Processor {
+	return New(envOptions()...)
+}
+
+// ProcessRecords emits the metrics of the given events.
+func (p *Processor) ProcessRecords(ctx context.Context, events []interface{}) error {
+	_, err := p.ProcessBatch(ctx, events)
+	return err
+}
+
+// ProcessBatch emits the metrics of the given events, and returns the outcome of each event. The events that
+// can't be encoded are rejected. The metrics of an event are sent in a single datagram, so that it's retryable
+// when the datagram couldn't be sent, without counting it twice. UDP doesn't tell whether the server got them.
+func (p *Processor) ProcessBatch(ctx context.Context, events []interface{}) (devspace.Result, error) {
+	ctx = trace.StartCall(ctx, "statsd.ProcessBatch")
+	defer trace.EndCall(ctx)
+
+	result := devspace.NewResult(len(events), devspace.Accepted)
+
+	var packets []packet
+	for i, e := range events {
+		m, err := p.metrics(e)
+		if err != nil {
+			result[i] = devspace.Rejected
+			continue
+		}
+		if len(m) == 0 {
+			continue
+		}
+
+		n := len(packets)
+		if n == 0 || (len(packets[n-1].data) > 0 && len(packets[n-1].data)+1+len(m) > maxPacketSize) {
+			packets = append(packets, packet{})
+			n++
+		}
+		packets[n-1].add(i, m)
+	}
+	trace.AddInfo(ctx, log.F{"statsd.packets": len(packets)})
+
+	if len(packets) == 0 {
+		return result, nil
+	}
+
+	conn, err := net.Dial("udp", p.addr)
+	if err != nil {
+		for _, pkt := range packets {
+			pkt.fail(result)
+		}
+		return result, trace.SetCallStatus(ctx, errors.Wrap(err, "failed to connect to statsd"))
+	}
+	//nolint:errcheck // Why: The datagrams are already sent.
+	defer conn.Close()
+
+	for i, pkt := range packets {
+		if _, err := conn.Write(pkt.data); err != nil {
+			for _, rest := range packets[i:] {
+				rest.fail(result)
+			}
+			return result, trace.SetCallStatus(ctx, errors.Wrap(err, "failed to send metrics"))
+		}
+	}
+
+	return result, nil
+}
+
+// metrics returns the lines of the metrics of the event, separated by newlines.
+func (p *Processor) metrics(event interface{}) ([]byte, error) {
+	data, err := devspace.Fields(event)
+	if err != nil {
+		return nil, err
+	}
+
+	hook := data.String("hook")
+	if hook == "" {
+		return nil, nil
+	}
+
+	tags := p.tags(map[string]string{
+		"hook":    hook,
+		"command": data.String("command.name"),
+		"runtime": data.String("devenv.runtime"),
+		"status":  data.String("status"),
+	})
+
+	lines := p.prefix + "hook.count:1|c" + tags
+	if duration := data.Int("duration_ms"); duration > 0 {
+		lines += "\n" + p.prefix + "hook.duration:" + strconv.FormatInt(duration, 10) + "|ms" + tags
+	}
+	return []byte(lines), nil
+}
+
+// tags returns the DogStatsD tags suffix of the metrics, in a fixed order. The empty tags are skipped.
+func (p *Processor) tags(values map[string]string) string {
+	if p.plain {
+		return ""
+	}
+
+	var tags []string
+	for _, name := range []string{"hook", "command", "runtime", "status"} {
+		if v := values[name]; v != "" {
+			tags = append(tags, name+":"+tagReplacer.Replace(v))
+		}
+	}
+	if len(tags) == 0 {
+		return ""
+	}
+	return "|#" + strings.Join(tags, ",")
+}
+
+// tagReplacer replaces the characters of the tag values that are separators in the DogStatsD protocol.
+var tagReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_", " ", "_")
+
+// packet is a datagram of metrics, and the indexes of the events they're of.
+type packet struct {
+	data    []byte
+	indexes []int
+}
+
+// add adds the metrics of the event to the packet.
+func (pkt *packet) add(index int, metrics []byte) {
+	if len(pkt.data) > 0 {
+		pkt.data = append(pkt.data, '\n')
+	}
+	pkt.data = append(pkt.data, metrics...)
+	pkt.indexes = append(pkt.indexes, index)
+}
+
+// fail marks the events of the packet retryable.
+func (pkt *packet) fail(result devspace.Result) {
+	for _, i := range pkt.indexes {
+		result[i] = devspace.Retryable
+	}
+}
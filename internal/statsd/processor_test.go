@@ -0,0 +1,123 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package statsd
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+	"github.com/getoutreach/devtel/internal/store"
+)
+
+// listen returns a local UDP listener, and a function returning the metrics it received.
+func listen(t *testing.T) (string, func(packets int) []string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn.LocalAddr().String(), func(packets int) []string {
+		var lines []string
+		buf := make([]byte, 2*maxPacketSize)
+		for i := 0; i < packets; i++ {
+			assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+			n, _, err := conn.ReadFrom(buf)
+			if !assert.NoError(t, err) {
+				break
+			}
+			assert.LessOrEqual(t, n, maxPacketSize)
+			lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+		}
+		return lines
+	}
+}
+
+func TestProcessorEmitsMetrics(t *testing.T) {
+	addr, received := listen(t)
+	p := New(WithAddr(addr))
+
+	events := []interface{}{
+		map[string]interface{}{
+			"hook":    "before:deploy",
+			"status":  "info",
+			"command": map[string]interface{}{"name": "deploy"},
+			"devenv":  map[string]interface{}{"runtime": "kind"},
+		},
+		map[string]interface{}{
+			"hook":        "after:deploy",
+			"status":      "info",
+			"command":     map[string]interface{}{"name": "deploy"},
+			"devenv":      map[string]interface{}{"runtime": "kind"},
+			"duration_ms": 9046,
+		},
+		map[string]interface{}{"hook": "error:build", "status": "error", "command": map[string]interface{}{"name": "dev, build"}},
+		map[string]interface{}{"event": "not a hook"},
+		func() {},
+	}
+
+	result, err := p.ProcessBatch(context.Background(), events)
+	assert.NoError(t, err)
+	assert.Equal(t, devspace.Result{
+		devspace.Accepted, devspace.Accepted, devspace.Accepted, devspace.Accepted, devspace.Rejected,
+	}, result)
+
+	assert.Equal(t, []string{
+		"devtel.hook.count:1|c|#hook:before:deploy,command:deploy,runtime:kind,status:info",
+		"devtel.hook.count:1|c|#hook:after:deploy,command:deploy,runtime:kind,status:info",
+		"devtel.hook.duration:9046|ms|#hook:after:deploy,command:deploy,runtime:kind,status:info",
+		"devtel.hook.count:1|c|#hook:error:build,command:dev__build,status:error",
+	}, received(1))
+}
+
+func TestProcessorSkipsDurationOfUnmatchedEvents(t *testing.T) {
+	addr, received := listen(t)
+
+	// The after hook is tracked without its before hook, it has no duration.
+	tracker := devspace.NewTracker(New(WithAddr(addr), WithPlain(true)), store.NewMemory())
+	tracker.Track(context.Background(), &devspace.Event{Hook: "after:deploy", ExecutionID: "1", Timestamp: 1651388151749})
+	assert.NoError(t, tracker.Flush(context.Background()))
+	assert.Equal(t, []string{"devtel.hook.count:1|c"}, received(1))
+}
+
+func TestProcessorSplitsPackets(t *testing.T) {
+	addr, received := listen(t)
+	p := New(WithAddr(addr), WithPrefix("dev."), WithPlain(true))
+
+	events := make([]interface{}, 200)
+	for i := range events {
+		events[i] = map[string]interface{}{"hook": "after:build", "duration_ms": 1000 + i}
+	}
+	assert.NoError(t, p.ProcessRecords(context.Background(), events))
+
+	// Each event is a count and a timing, of about 45 bytes.
+	lines := received(7)
+	assert.Len(t, lines, 400)
+	assert.Equal(t, "dev.hook.count:1|c", lines[0])
+	assert.Equal(t, "dev.hook.duration:1000|ms", lines[1])
+	assert.Equal(t, "dev.hook.duration:1199|ms", lines[399])
+}
+
+func TestProcessorRetriesUnreachableServer(t *testing.T) {
+	p := New(WithAddr("localhost:not-a-port"))
+	result, err := p.ProcessBatch(context.Background(), []interface{}{map[string]interface{}{"hook": "before:build"}})
+	assert.Error(t, err)
+	assert.Equal(t, devspace.Result{devspace.Retryable}, result)
+}
+
+func TestEnvOptions(t *testing.T) {
+	t.Setenv("DEVTEL_STATSD_ADDR", "")
+	t.Setenv("DD_AGENT_HOST", "datadog")
+	t.Setenv("DD_DOGSTATSD_PORT", "")
+	t.Setenv("DEVTEL_STATSD_PLAIN", "true")
+	p := NewProcessor()
+	assert.Equal(t, "datadog:8125", p.addr)
+	assert.True(t, p.plain)
+
+	t.Setenv("DEVTEL_STATSD_ADDR", "127.0.0.1:9125")
+	assert.Equal(t, "127.0.0.1:9125", NewProcessor().addr)
+}
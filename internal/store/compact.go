@@ -0,0 +1,136 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the log compaction.
+
+package store
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/getoutreach/gobox/pkg/log"
+	"github.com/getoutreach/gobox/pkg/trace"
+	"github.com/pkg/errors"
+)
+
+// Compact rewrites the log files into a single file that holds only the latest version of each entry.
+// Processed entries older than the processed retention are dropped.
+// The new file is swapped in by rename and sorts after the old files, which are removed afterwards. If the removal
+// fails, the old files only hold older versions of the entries and they are superseded on restore.
+func (s *FSStore) Compact(ctx context.Context) error {
+	ctx = trace.StartCall(ctx, "store.Compact")
+	defer trace.EndCall(ctx)
+
+	if !s.managed {
+		return trace.SetCallStatus(ctx, fmt.Errorf("compaction requires the store to manage the log dir"))
+	}
+	if s.offsets == nil {
+		return trace.SetCallStatus(ctx, fmt.Errorf("store is not initialized"))
+	}
+
+	if err := s.locker.Lock(); err != nil {
+		return trace.SetCallStatus(ctx, errors.Wrap(err, "failed to lock log dir"))
+	}
+	//nolint:errcheck // Why: The lock is released with the lock file being closed.
+	defer s.locker.Unlock()
+
+	if err := s.refresh(); err != nil {
+		return trace.SetCallStatus(ctx, err)
+	}
+
+	cutoff := time.Now().Add(-s.processedRetention).UnixMilli()
+	kept := s.latest(func(r *record) bool {
+		return !r.expired(cutoff)
+	})
+	indexed := len(s.index)
+
+	name := s.newLogName()
+	size, err := s.writeLog(name, kept)
+	if err != nil {
+		return trace.SetCallStatus(ctx, errors.Wrap(err, "failed to write compacted log"))
+	}
+
+	for old := range s.offsets {
+		if err := os.Remove(filepath.Join(s.logDir, old)); err != nil && !os.IsNotExist(err) {
+			return trace.SetCallStatus(ctx, errors.Wrapf(err, "failed to remove %s", old))
+		}
+	}
+
+	trace.AddInfo(ctx, log.F{
+		"store.compacted_entries": indexed,
+		"store.kept_entries":      len(kept),
+	})
+
+	s.resetIndex()
+	for i := range kept {
+		s.indexRecord(kept[i])
+	}
+	s.offsets = map[string]int64{name: size}
+	s.files = nil
+	s.lastName = name
+	s.logName = name
+	s.logPath = filepath.Join(s.logDir, name)
+
+	return trace.SetCallStatus(ctx, nil)
+}
+
+// writeLog copies the log lines of the records into a temporary file and renames it to name once it's synced
+// to disk. The records are updated to point to the new file. It returns the size of the written file.
+func (s *FSStore) writeLog(name string, records []record) (int64, error) {
+	tmpPath := filepath.Join(s.logDir, "."+name+".tmp")
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	//nolint:errcheck // Why: The file is closed explicitly below, this handles the error paths.
+	defer os.Remove(tmpPath)
+	defer f.Close()
+
+	rr := recordReader{fsys: s.logFS}
+	//nolint:errcheck // Why: The files are only read.
+	defer rr.Close()
+
+	var size int64
+	w := bufio.NewWriter(f)
+	for i := range records {
+		line, err := rr.line(&records[i])
+		if err != nil {
+			return 0, err
+		}
+		if _, err := w.Write(line); err != nil {
+			return 0, err
+		}
+
+		records[i].file = name
+		records[i].offset = size
+		records[i].length = len(line)
+		records[i].data = nil
+		size += int64(len(line))
+	}
+
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+
+	return size, os.Rename(tmpPath, filepath.Join(s.logDir, name))
+}
+
+// newLogName returns a name for a new log file. It sorts after the existing log files.
+func (s *FSStore) newLogName() string {
+	name := fmt.Sprintf("%d.log", time.Now().UnixNano())
+	if name <= s.lastName {
+		name = s.lastName[:len(s.lastName)-len(filepath.Ext(s.lastName))] + "_1.log"
+	}
+
+	return name
+}
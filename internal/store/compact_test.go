@@ -0,0 +1,88 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour).UnixMilli()
+	recent := time.Now().UnixMilli()
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "1.log"), []byte(""+
+		`{"key":"before:deploy","data":{"id":"before:deploy","timestamp":`+itoa(old)+`}}`+"\n"+
+		`{"key":"after:deploy","data":{"id":"after:deploy","timestamp":`+itoa(recent)+`}}`+"\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "2.log"), []byte(""+
+		`{"key":"before:deploy","data":{"id":"before:deploy","timestamp":`+itoa(old)+`},"processed":true}`+"\n"+
+		`{"key":"after:deploy","data":{"id":"after:deploy","timestamp":`+itoa(recent)+`},"processed":true}`+"\n"+
+		`{"key":"after:build","data":{"id":"after:build","timestamp":`+itoa(old)+`}}`+"\n"), 0o600))
+
+	s := New(&Options{LogDir: dir, ProcessedRetention: 24 * time.Hour})
+	assert.NoError(t, s.Init(context.Background()))
+	assert.NoError(t, s.Compact(context.Background()))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	b, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		`{"key":"after:deploy","data":{"id":"after:deploy","timestamp":`+itoa(recent)+`},"processed":true}`+"\n"+
+		`{"key":"after:build","data":{"id":"after:build","timestamp":`+itoa(old)+`}}`+"\n", string(b))
+
+	assert.NoError(t, s.Append(context.Background(), &payload{ID: "after:purge"}))
+	assert.Equal(t, 3, s.GetAll(context.Background()).Len())
+
+	restored := New(&Options{LogDir: dir})
+	assert.NoError(t, restored.Init(context.Background()))
+	assert.Equal(t, 3, restored.GetAll(context.Background()).Len())
+	assert.Equal(t, 2, restored.GetUnprocessed(context.Background()).Len())
+}
+
+func TestInitCompactsAfterThreshold(t *testing.T) {
+	dir := t.TempDir()
+	s := New(&Options{LogDir: dir})
+	assert.NoError(t, s.Init(context.Background()))
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, s.Append(context.Background(), &payload{ID: "before:deploy"}))
+	}
+
+	s = New(&Options{LogDir: dir, CompactionThreshold: 2})
+	assert.NoError(t, s.Init(context.Background()))
+	assert.Len(t, s.index, 1)
+
+	b, err := os.ReadFile(s.logPath)
+	assert.NoError(t, err)
+	assert.Equal(t, LogLine(`{"key":"before:deploy","data":{"id":"before:deploy"}}`), string(b))
+}
+
+func TestAppendFollowsNewLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	s1 := New(&Options{LogDir: dir})
+	s2 := New(&Options{LogDir: dir})
+	assert.NoError(t, s1.Init(context.Background()))
+	assert.NoError(t, s1.Append(context.Background(), &payload{ID: "before:deploy"}))
+	assert.NoError(t, s2.Init(context.Background()))
+
+	assert.NoError(t, s1.Compact(context.Background()))
+	assert.NoError(t, s2.MarkProcessed(context.Background(), []IndexMarshaller{&payload{ID: "before:deploy"}}))
+	assert.Equal(t, s1.logName, s2.logName)
+
+	restored := New(&Options{LogDir: dir})
+	assert.NoError(t, restored.Init(context.Background()))
+	assert.Equal(t, 0, restored.GetUnprocessed(context.Background()).Len())
+}
+
+func itoa(v int64) string {
+	return strconv.FormatInt(v, 10)
+}
@@ -7,10 +7,15 @@ package store
 import "fmt"
 
 // Cursor implements iteration over generic event data.
+// The data is either held by the cursor, or loaded on demand as the cursor is iterated.
 type Cursor struct {
 	currIndex int
 
 	items []map[string]interface{}
+
+	n       int
+	load    func(i int) (map[string]interface{}, error)
+	release func() error
 }
 
 // NewCursor creates new cursor instance for given items.
@@ -18,15 +23,30 @@ func NewCursor(items []map[string]interface{}) *Cursor {
 	return &Cursor{
 		currIndex: -1,
 		items:     items,
+		n:         len(items),
+	}
+}
+
+// newLazyCursor creates new cursor instance over n items, that are loaded on demand.
+// release is called once the cursor is exhausted or closed.
+func newLazyCursor(n int, load func(i int) (map[string]interface{}, error), release func() error) *Cursor {
+	return &Cursor{
+		currIndex: -1,
+		n:         n,
+		load:      load,
+		release:   release,
 	}
 }
 
 // Next moves cursor to next item.
 func (c *Cursor) Next() bool {
-	if c.currIndex+1 < len(c.items) {
+	if c.currIndex+1 < c.n {
 		c.currIndex++
 		return true
 	}
+
+	//nolint:errcheck // Why: There's nothing left to read.
+	c.Close()
 	return false
 }
 
@@ -36,10 +56,30 @@ func (c *Cursor) Value(v IndexMarshaller) error {
 		return fmt.Errorf("cursor is not positioned")
 	}
 
-	return v.UnmarshalRecord(c.items[c.currIndex])
+	if c.load == nil {
+		return v.UnmarshalRecord(c.items[c.currIndex])
+	}
+
+	data, err := c.load(c.currIndex)
+	if err != nil {
+		return err
+	}
+	return v.UnmarshalRecord(data)
 }
 
 // Len returns number of items in cursor.
 func (c *Cursor) Len() int {
-	return len(c.items)
+	return c.n
+}
+
+// Close releases the resources held by the cursor. It's called when the cursor is exhausted,
+// it only needs to be called when the iteration is stopped early.
+func (c *Cursor) Close() error {
+	if c.release == nil {
+		return nil
+	}
+
+	release := c.release
+	c.release = nil
+	return release()
 }
@@ -0,0 +1,69 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkDelivered(t *testing.T) {
+	stores := map[string]func(dir string) Store{
+		"jsonl":  func(dir string) Store { return New(&Options{LogDir: dir}) },
+		"sqlite": func(dir string) Store { return NewSQLite(&Options{LogDir: dir}) },
+		"memory": func(string) Store { return NewMemory() },
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			s := newStore(dir)
+			assert.NoError(t, s.Init(ctx))
+
+			one, two := &payload{ID: "1", Content: "one"}, &payload{ID: "2", Content: "two"}
+			assert.NoError(t, s.Append(ctx, one))
+			assert.NoError(t, s.Append(ctx, two))
+
+			assert.NoError(t, s.MarkDelivered(ctx, "telefork", []IndexMarshaller{one}))
+			assert.NoError(t, s.MarkDelivered(ctx, "otlp", []IndexMarshaller{one, two}))
+			// Marking again keeps the sink listed once.
+			assert.NoError(t, s.MarkDelivered(ctx, "otlp", []IndexMarshaller{one}))
+
+			processed := false
+			undelivered := func(s Store, sink string) []string {
+				var ids []string
+				c := s.Query(ctx, Filter{Processed: &processed, Undelivered: sink})
+				for c.Next() {
+					var p payload
+					assert.NoError(t, c.Value(&p))
+					ids = append(ids, p.ID)
+				}
+				return ids
+			}
+			assert.Equal(t, []string{"2"}, undelivered(s, "telefork"))
+			assert.Empty(t, undelivered(s, "otlp"))
+			assert.ElementsMatch(t, []string{"1", "2"}, undelivered(s, "file"))
+
+			// The events delivered somewhere are still unprocessed.
+			assert.Equal(t, 2, s.GetUnprocessed(ctx).Len())
+
+			// The delivery state is persisted.
+			if closer, ok := s.(interface{ Close() error }); ok {
+				assert.NoError(t, closer.Close())
+			}
+			if name != "memory" {
+				s = newStore(dir)
+				assert.NoError(t, s.Init(ctx))
+				assert.Equal(t, []string{"2"}, undelivered(s, "telefork"))
+				assert.Empty(t, undelivered(s, "otlp"))
+			}
+
+			// A new version of the event is not delivered anywhere.
+			assert.NoError(t, s.Append(ctx, &payload{ID: "1", Content: "one again"}))
+			assert.ElementsMatch(t, []string{"1", "2"}, undelivered(s, "telefork"))
+		})
+	}
+}
@@ -13,7 +13,6 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-var tmpDir = "../../tmp/test"
 var eventID = fmt.Sprintf("id_%d", time.Now().UnixNano())
 
 type testEvent struct {
@@ -34,8 +33,7 @@ func (e *testEvent) UnmarshalRecord(data map[string]interface{}) error {
 }
 
 func TestStoreData(t *testing.T) {
-	os.RemoveAll(tmpDir)
-	os.MkdirAll(tmpDir, 0o777)
+	tmpDir := t.TempDir()
 
 	// This file will get picked up automatically by the store
 	f, err := os.CreateTemp(tmpDir, "*.log")
@@ -44,31 +42,31 @@ func TestStoreData(t *testing.T) {
 	tempFile := f.Name()
 	assert.NoError(t, f.Close())
 
-	appendToFile(t)
-	expected := fmt.Sprintf(`{"key":%q,"data":{"id":%q}}`, eventID, eventID) + "\n"
+	appendToFile(t, tmpDir)
+	expected := store.LogLine(fmt.Sprintf(`{"key":%q,"data":{"id":%q}}`, eventID, eventID))
 
 	b, err := os.ReadFile(tempFile)
 	assert.NoError(t, err)
 	assert.Equal(t, expected, string(b))
 
-	restoreFormFile(t)
+	restoreFormFile(t, tmpDir)
 
 	b, err = os.ReadFile(tempFile)
 	assert.NoError(t, err)
 	// The file doesn't change, it's just being read
 	assert.Equal(t, expected, string(b))
 
-	processEvents(t)
+	processEvents(t, tmpDir)
 
 	b, err = os.ReadFile(tempFile)
 	assert.NoError(t, err)
 
-	expected += fmt.Sprintf(`{"key":%q,"data":{"id":%q},"processed":true}`, eventID, eventID) + "\n"
+	expected += store.LogLine(fmt.Sprintf(`{"key":%q,"data":{"id":%q},"processed":true}`, eventID, eventID))
 
 	assert.Equal(t, expected, string(b))
 }
 
-func appendToFile(t *testing.T) {
+func appendToFile(t *testing.T, tmpDir string) {
 	s := store.New(&store.Options{
 		LogDir: tmpDir,
 	})
@@ -76,7 +74,7 @@ func appendToFile(t *testing.T) {
 	assert.NoError(t, s.Append(context.Background(), &testEvent{ID: eventID}))
 }
 
-func restoreFormFile(t *testing.T) {
+func restoreFormFile(t *testing.T, tmpDir string) {
 	s := store.New(&store.Options{
 		LogDir: tmpDir,
 	})
@@ -86,7 +84,7 @@ func restoreFormFile(t *testing.T) {
 	assert.NotEmpty(t, e)
 }
 
-func processEvents(t *testing.T) {
+func processEvents(t *testing.T, tmpDir string) {
 	s := store.New(&store.Options{
 		LogDir: tmpDir,
 	})
@@ -0,0 +1,90 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the checksummed envelope of the log lines.
+
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+)
+
+// The log lines are written in an envelope that carries the length and the CRC-32C checksum of the entry:
+//
+//	{"len":"<length, 8 hex digits>","crc":"<checksum, 8 hex digits>","entry":<entry JSON>}\n
+//
+// It detects the lines that were torn or merged by crashed writers, as well as bit rot, which would otherwise
+// still be valid JSON. The lines written before the envelope was introduced are plain JSON, they're read as is.
+// The envelope is a JSON object without a key, so that the older versions of devtel sharing the log dir skip
+// the enveloped lines instead of repairing them away as corrupt. They don't see the events tracked by the newer
+// versions though, and their compaction drops them, so the log dir is meant to move to the envelope one way.
+
+// envelopeHeader is the format of the envelope before the entry, envelopeHeaderLen is its length.
+const (
+	envelopeHeader    = `{"len":"%08x","crc":"%08x","entry":`
+	envelopeHeaderLen = 43
+)
+
+// envelopePrefix tells the enveloped lines from the plain JSON ones.
+var envelopePrefix = []byte(`{"len":"`)
+
+// envelopeTrailer closes the envelope after the entry.
+const envelopeTrailer = "}\n"
+
+// crcTable is the CRC-32C (Castagnoli) table, it's hardware accelerated on most platforms.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// encodeLine wraps the marshaled entry into the envelope, including the newline.
+func encodeLine(b []byte) []byte {
+	line := make([]byte, 0, envelopeHeaderLen+len(b)+len(envelopeTrailer))
+	line = append(line, fmt.Sprintf(envelopeHeader, len(b), crc32.Checksum(b, crcTable))...)
+	line = append(line, b...)
+	return append(line, envelopeTrailer...)
+}
+
+// decodeLine returns the marshaled entry in the log line. It fails when the envelope doesn't match the entry.
+// The plain JSON lines are returned without verification, besides that they're terminated.
+func decodeLine(line []byte) ([]byte, error) {
+	if !bytes.HasSuffix(line, []byte("\n")) {
+		return nil, fmt.Errorf("log line is incomplete")
+	}
+
+	if !bytes.HasPrefix(line, envelopePrefix) {
+		if bytes.HasPrefix(line, []byte("{")) {
+			return line[:len(line)-1], nil
+		}
+		return nil, fmt.Errorf("log line has no envelope")
+	}
+
+	if len(line) < envelopeHeaderLen+len(envelopeTrailer) || !bytes.HasSuffix(line, []byte(envelopeTrailer)) {
+		return nil, fmt.Errorf("log line has invalid envelope")
+	}
+
+	length, err := strconv.ParseUint(string(line[8:16]), 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("log line has invalid length")
+	}
+	sum, err := strconv.ParseUint(string(line[25:33]), 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("log line has invalid checksum")
+	}
+
+	b := line[envelopeHeaderLen : len(line)-len(envelopeTrailer)]
+	if uint64(len(b)) != length {
+		return nil, fmt.Errorf("log line length %d doesn't match %d", len(b), length)
+	}
+	if uint64(crc32.Checksum(b, crcTable)) != sum {
+		return nil, fmt.Errorf("log line checksum doesn't match")
+	}
+
+	return b, nil
+}
+
+// validLine returns true if the log line holds a complete and intact entry.
+func validLine(line []byte) bool {
+	b, err := decodeLine(line)
+	return err == nil && json.Valid(b)
+}
@@ -0,0 +1,69 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeLine(t *testing.T) {
+	entry := `{"key":"id1","data":{"id":"id1"}}`
+	line := LogLine(entry)
+
+	b, err := decodeLine([]byte(line))
+	assert.NoError(t, err)
+	assert.Equal(t, entry, string(b))
+
+	// The older versions of the store skip the enveloped lines, as they have no key.
+	var fields map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(line), &fields))
+	assert.NotContains(t, fields, "key")
+
+	// Plain JSON lines are read as is.
+	b, err = decodeLine([]byte(entry + "\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, entry, string(b))
+
+	flipped := []byte(line)
+	flipped[len(flipped)-5] = '2'
+	for name, line := range map[string]string{
+		"incomplete": line[:len(line)-1],
+		"torn":       line[:len(line)-10] + "\n",
+		"merged":     line[:len(line)-10] + line,
+		"bit flip":   string(flipped),
+		"no header":  "0000 " + entry + "\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := decodeLine([]byte(line))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRestoresPlainAndEnvelopedLines(t *testing.T) {
+	dir := t.TempDir()
+	legacy := `{"key":"before:deploy","data":{"id":"before:deploy"}}` + "\n"
+	// The checksum catches the damage that leaves the line valid JSON.
+	damaged := []byte(LogLine(`{"key":"after:deploy","data":{"id":"after:deploy"}}`))
+	damaged[len(damaged)-6] = 'X'
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "1.log"), append([]byte(legacy), damaged...), 0o600))
+
+	s := New(&Options{LogDir: dir, Sync: SyncAlways})
+	assert.NoError(t, s.Init(context.Background()))
+	assert.NoError(t, s.Append(context.Background(), &payload{ID: "after:build"}))
+
+	b, err := os.ReadFile(filepath.Join(dir, "1.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, legacy+LogLine(`{"key":"after:build","data":{"id":"after:build"}}`), string(b))
+
+	restored := New(&Options{LogDir: dir})
+	assert.NoError(t, restored.Init(context.Background()))
+	assert.Equal(t, 0, restored.corrupt)
+	assert.Equal(t, 2, restored.GetAll(context.Background()).Len())
+}
@@ -0,0 +1,8 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package store
+
+// LogLine returns the log line of the marshaled entry, in the envelope the store writes it in.
+func LogLine(entry string) string {
+	return string(encodeLine([]byte(entry)))
+}
@@ -0,0 +1,257 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the in-memory index of the log files and reading of the indexed entries.
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// record is the index entry of the latest version of an entry. It locates the entry in the log files,
+// and holds just enough of it to decide on processing, rotation and compaction without reading it.
+type record struct {
+	key    string
+	file   string
+	offset int64
+	length int
+
+	// seq is the order in which the records were indexed.
+	seq       int
+	processed bool
+	// delivered holds the sinks the unprocessed entry was delivered to.
+	delivered []string
+
+	timestamp    int64
+	hasTimestamp bool
+
+	// data holds the entry data when it can't be read back from the log files, e.g. when it was appended
+	// through a custom OpenAppend or before the store was initialized.
+	data map[string]interface{}
+}
+
+// expired returns true if the record is processed and older than cutoff (unix milliseconds).
+func (r *record) expired(cutoff int64) bool {
+	return r.processed && r.hasTimestamp && r.timestamp < cutoff
+}
+
+// header is the part of the log line that is kept in the index.
+type header struct {
+	Key  string `json:"key"`
+	Data *struct {
+		Timestamp interface{} `json:"timestamp"`
+	} `json:"data"`
+	Processed bool     `json:"processed"`
+	Delivered []string `json:"delivered"`
+}
+
+// indexRecord adds the record to the in-memory index, superseding the previous version of the entry.
+func (s *FSStore) indexRecord(r record) {
+	if s.index == nil {
+		s.index = make(map[string]record)
+	}
+	if s.segmentEntries == nil {
+		s.segmentEntries = make(map[string]int)
+	}
+
+	s.segmentEntries[r.file]++
+	r.seq = s.seq
+	s.seq++
+	s.index[r.key] = r
+}
+
+// resetIndex drops the in-memory index.
+func (s *FSStore) resetIndex() {
+	s.index = nil
+	s.segmentEntries = nil
+	s.seq = 0
+}
+
+// reload drops the in-memory index and restores all the log files again.
+// It's used after the log files were rewritten, and the records could point to wrong offsets.
+func (s *FSStore) reload() error {
+	if s.offsets == nil {
+		return nil
+	}
+
+	s.resetIndex()
+	s.offsets = make(map[string]int64)
+	s.files = nil
+	return s.refresh()
+}
+
+// dropFiles removes the records located in given log files from the index.
+func (s *FSStore) dropFiles(files map[string]bool) {
+	for key, r := range s.index {
+		if r.data == nil && files[r.file] {
+			delete(s.index, key)
+		}
+	}
+	for file := range files {
+		delete(s.segmentEntries, file)
+	}
+}
+
+// latest returns the records accepted by filter, in the order they were indexed.
+func (s *FSStore) latest(filter func(*record) bool) []record {
+	records := make([]record, 0, len(s.index))
+	for _, r := range s.index {
+		if filter == nil || filter(&r) {
+			records = append(records, r)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].seq < records[j].seq
+	})
+
+	return records
+}
+
+// cursor returns a cursor that reads the records from the log files as it's iterated.
+func (s *FSStore) cursor(records []record) *Cursor {
+	r := &recordReader{fsys: s.logFS}
+	return newLazyCursor(len(records), func(i int) (map[string]interface{}, error) {
+		e, err := r.entry(&records[i])
+		if err != nil {
+			return nil, err
+		}
+		return e.Data, nil
+	}, r.Close)
+}
+
+// recordReader reads the indexed entries from the log files. It keeps the last used file open, as records
+// are usually read in the order they were appended.
+type recordReader struct {
+	fsys fs.FS
+
+	name string
+	f    fs.File
+}
+
+// entry reads and decodes the entry of the record.
+func (rr *recordReader) entry(r *record) (entry, error) {
+	if r.data != nil {
+		return entry{Key: r.key, Data: r.data, Processed: r.processed, Delivered: r.delivered}, nil
+	}
+
+	line, err := rr.line(r)
+	if err != nil {
+		return entry{}, err
+	}
+
+	b, err := decodeLine(line)
+	if err != nil {
+		return entry{}, errors.Wrapf(err, "failed to read entry %s", r.key)
+	}
+
+	var e entry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return entry{}, errors.Wrapf(err, "failed to unmarshal entry %s", r.key)
+	}
+
+	return e, nil
+}
+
+// line reads the raw log line of the record, including the newline.
+func (rr *recordReader) line(r *record) ([]byte, error) {
+	if r.data != nil {
+		b, err := json.Marshal(entry{Key: r.key, Data: r.data, Processed: r.processed, Delivered: r.delivered})
+		if err != nil {
+			return nil, err
+		}
+		return encodeLine(b), nil
+	}
+
+	if rr.f == nil || rr.name != r.file {
+		if err := rr.Close(); err != nil {
+			return nil, err
+		}
+
+		f, err := rr.fsys.Open(r.file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open %s", r.file)
+		}
+		rr.f = f
+		rr.name = r.file
+	}
+
+	b := make([]byte, r.length)
+	switch f := rr.f.(type) {
+	case io.ReaderAt:
+		if _, err := f.ReadAt(b, r.offset); err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", r.file)
+		}
+	case io.ReadSeeker:
+		if _, err := f.Seek(r.offset, io.SeekStart); err != nil {
+			return nil, errors.Wrapf(err, "failed to seek %s", r.file)
+		}
+		if _, err := io.ReadFull(f, b); err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", r.file)
+		}
+	default:
+		return nil, fmt.Errorf("log file %s doesn't support random access", r.file)
+	}
+
+	return b, nil
+}
+
+// Close closes the open log file.
+func (rr *recordReader) Close() error {
+	if rr.f == nil {
+		return nil
+	}
+
+	f := rr.f
+	rr.f = nil
+	rr.name = ""
+	return f.Close()
+}
+
+// mergeSinks returns the sinks in either of the lists, without duplicates.
+func mergeSinks(a, b []string) []string {
+	merged := make([]string, 0, len(a)+len(b))
+	seen := make(map[string]bool, len(a)+len(b))
+	for _, sinks := range [][]string{a, b} {
+		for _, sink := range sinks {
+			if !seen[sink] {
+				seen[sink] = true
+				merged = append(merged, sink)
+			}
+		}
+	}
+
+	return merged
+}
+
+// delivered returns true if the sink is in the list of sinks.
+func delivered(sinks []string, sink string) bool {
+	for _, s := range sinks {
+		if s == sink {
+			return true
+		}
+	}
+	return false
+}
+
+// millis converts a decoded timestamp into unix milliseconds.
+func millis(v interface{}) (int64, bool) {
+	switch ts := v.(type) {
+	case float64:
+		return int64(ts), true
+	case int64:
+		return ts, true
+	case int:
+		return int64(ts), true
+	case json.Number:
+		v, err := ts.Int64()
+		return v, err == nil
+	default:
+		return 0, false
+	}
+}
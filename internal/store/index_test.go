@@ -0,0 +1,65 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexKeepsOnlyLocations(t *testing.T) {
+	dir := t.TempDir()
+	s := New(&Options{LogDir: dir})
+	assert.NoError(t, s.Init(context.Background()))
+
+	assert.NoError(t, s.Append(context.Background(), &payload{ID: "id1"}))
+	assert.NoError(t, s.Append(context.Background(), &payload{ID: "id2"}))
+	assert.NoError(t, s.Append(context.Background(), &payload{ID: "id1", Content: "content1"}))
+
+	restored := New(&Options{LogDir: dir})
+	assert.NoError(t, restored.Init(context.Background()))
+
+	for _, st := range []*FSStore{s, restored} {
+		assert.Len(t, st.index, 2)
+		for _, r := range st.index {
+			assert.Nil(t, r.data)
+		}
+
+		cursor := st.GetAll(context.Background())
+		assert.Equal(t, 2, cursor.Len())
+
+		var curr payload
+		assert.True(t, cursor.Next())
+		assert.NoError(t, cursor.Value(&curr))
+		assert.Equal(t, "id2", curr.ID)
+
+		assert.True(t, cursor.Next())
+		assert.NoError(t, cursor.Value(&curr))
+		assert.Equal(t, "id1", curr.ID)
+		assert.Equal(t, "content1", curr.Content)
+
+		assert.False(t, cursor.Next())
+	}
+}
+
+func TestCursorClosesLogFile(t *testing.T) {
+	s := New(&Options{LogDir: t.TempDir()})
+	assert.NoError(t, s.Init(context.Background()))
+	assert.NoError(t, s.Append(context.Background(), &payload{ID: "id1"}))
+
+	rr := recordReader{fsys: s.logFS}
+	r := s.index["id1"]
+	e, err := rr.entry(&r)
+	assert.NoError(t, err)
+	assert.Equal(t, "id1", e.Data["id"])
+	assert.NotNil(t, rr.f)
+
+	cursor := newLazyCursor(1, func(int) (map[string]interface{}, error) {
+		return e.Data, nil
+	}, rr.Close)
+	assert.True(t, cursor.Next())
+	assert.False(t, cursor.Next())
+	assert.Nil(t, rr.f)
+}
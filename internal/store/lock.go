@@ -0,0 +1,132 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the advisory locking used to coordinate access to the log dir across processes.
+
+package store
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Locker coordinates access to the store across processes.
+type Locker interface {
+	// RLock acquires a shared lock.
+	RLock() error
+	// Lock acquires an exclusive lock.
+	Lock() error
+	// Unlock releases the lock acquired by RLock or Lock.
+	Unlock() error
+}
+
+// fileLocker implements Locker using an advisory lock on a file.
+// Locks are reentrant, nested calls only increase the lock depth. A nested Lock upgrades a shared lock
+// to exclusive one until the outermost Unlock. It's not safe for concurrent use within a process.
+type fileLocker struct {
+	path string
+
+	f         *os.File
+	depth     int
+	exclusive bool
+}
+
+// NewFileLocker returns a Locker that locks the file at given path. The file is created if it doesn't exist.
+func NewFileLocker(path string) Locker {
+	return &fileLocker{path: path}
+}
+
+// RLock acquires a shared lock.
+func (l *fileLocker) RLock() error {
+	return l.lock(false)
+}
+
+// Lock acquires an exclusive lock.
+func (l *fileLocker) Lock() error {
+	return l.lock(true)
+}
+
+// lock acquires the lock, or upgrades it if exclusive lock is requested while holding a shared one.
+func (l *fileLocker) lock(exclusive bool) error {
+	if l.depth > 0 {
+		if exclusive && !l.exclusive {
+			if err := flock(l.f, true); err != nil {
+				return err
+			}
+			l.exclusive = true
+		}
+		l.depth++
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := flock(f, exclusive); err != nil {
+		f.Close()
+		return err
+	}
+
+	l.f = f
+	l.depth = 1
+	l.exclusive = exclusive
+
+	return nil
+}
+
+// Unlock releases the lock once the outermost lock call is matched.
+func (l *fileLocker) Unlock() error {
+	if l.depth == 0 {
+		return nil
+	}
+
+	l.depth--
+	if l.depth > 0 {
+		return nil
+	}
+
+	f := l.f
+	l.f = nil
+	l.exclusive = false
+	if err := funlock(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// TryLockFile acquires an exclusive advisory lock on the file at given path without blocking. The file is
+// created if it doesn't exist. It returns false if the lock is held by another process, otherwise
+// the returned function releases the lock.
+func TryLockFile(path string) (func(), bool, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, false, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ok, err := tryFlock(f)
+	if err != nil || !ok {
+		f.Close()
+		return nil, false, err
+	}
+
+	return func() {
+		//nolint:errcheck // Why: The lock is released with the file being closed.
+		funlock(f)
+		f.Close()
+	}, true, nil
+}
+
+// nopLocker is a Locker that doesn't lock anything. It's used when the store doesn't manage the log files itself.
+type nopLocker struct{}
+
+func (nopLocker) RLock() error  { return nil }
+func (nopLocker) Lock() error   { return nil }
+func (nopLocker) Unlock() error { return nil }
@@ -0,0 +1,25 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains no-op file locking for systems without flock.
+
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package store
+
+import "os"
+
+// flock is a no-op, we only release for linux and darwin.
+func flock(_ *os.File, _ bool) error {
+	return nil
+}
+
+// tryFlock always succeeds, we only release for linux and darwin.
+func tryFlock(_ *os.File) (bool, error) {
+	return true, nil
+}
+
+// funlock is a no-op, we only release for linux and darwin.
+func funlock(_ *os.File) error {
+	return nil
+}
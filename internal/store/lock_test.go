@@ -0,0 +1,93 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileLockerIsExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock")
+	l1 := NewFileLocker(path)
+	l2 := NewFileLocker(path)
+
+	assert.NoError(t, l1.Lock())
+
+	acquired := make(chan struct{})
+	go func() {
+		assert.NoError(t, l2.Lock())
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("lock acquired while held by another locker")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.NoError(t, l1.Unlock())
+	<-acquired
+	assert.NoError(t, l2.Unlock())
+}
+
+func TestFileLockerIsReentrant(t *testing.T) {
+	l := NewFileLocker(filepath.Join(t.TempDir(), ".lock")).(*fileLocker)
+
+	assert.NoError(t, l.RLock())
+	assert.NoError(t, l.Lock())
+	assert.True(t, l.exclusive)
+
+	assert.NoError(t, l.Unlock())
+	assert.NotNil(t, l.f)
+	assert.NoError(t, l.Unlock())
+	assert.Nil(t, l.f)
+}
+
+func TestStoresShareLogDir(t *testing.T) {
+	dir := t.TempDir()
+	s1 := New(&Options{LogDir: dir})
+	s2 := New(&Options{LogDir: dir})
+
+	assert.NoError(t, s1.Init(context.Background()))
+	assert.NoError(t, s2.Init(context.Background()))
+
+	assert.NoError(t, s1.Append(context.Background(), &payload{ID: "before:deploy"}))
+
+	unlock, err := s2.Lock(context.Background())
+	assert.NoError(t, err)
+	var before payload
+	assert.NoError(t, s2.Get(context.Background(), "before:deploy", &before))
+	assert.Equal(t, "before:deploy", before.ID)
+	assert.NoError(t, s2.Append(context.Background(), &payload{ID: "after:deploy"}))
+	unlock()
+
+	assert.NoError(t, s1.MarkProcessed(context.Background(), []IndexMarshaller{&payload{ID: "after:deploy"}}))
+
+	s3 := New(&Options{LogDir: dir})
+	assert.NoError(t, s3.Init(context.Background()))
+	assert.Equal(t, 2, s3.GetAll(context.Background()).Len())
+	assert.Equal(t, 1, s3.GetUnprocessed(context.Background()).Len())
+}
+
+func TestTryLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".flush.lock")
+
+	unlock, ok, err := TryLockFile(path)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	_, ok, err = TryLockFile(path)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	unlock()
+	unlock, ok, err = TryLockFile(path)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	unlock()
+}
@@ -0,0 +1,50 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains flock based file locking for unix systems.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package store
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// flock acquires an advisory lock on the file, blocking until it's available.
+func flock(f *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if !errors.Is(err, syscall.EINTR) {
+			return err
+		}
+	}
+}
+
+// tryFlock acquires an exclusive advisory lock on the file without blocking.
+// It returns false if the lock is held by someone else.
+func tryFlock(f *os.File) (bool, error) {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		switch {
+		case err == nil:
+			return true, nil
+		case errors.Is(err, syscall.EWOULDBLOCK):
+			return false, nil
+		case !errors.Is(err, syscall.EINTR):
+			return false, err
+		}
+	}
+}
+
+// funlock releases the advisory lock on the file.
+func funlock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,171 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the in-memory implementation of Store.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/getoutreach/gobox/pkg/trace"
+)
+
+// MemoryStore is the implementation of Store that keeps the events in memory. It's meant for tests and
+// for environments where writing to disk is undesirable, the events are lost when the process exits.
+// The events are stored in their JSON form, so they read back the same as from the other stores.
+type MemoryStore struct {
+	// mu guards the data of the store.
+	mu    sync.Mutex
+	index map[string]record
+	seq   int
+
+	// lock is held by Lock. It's separate from mu, so that the store can be used while it's locked.
+	lock sync.Mutex
+
+	defaultFields bag
+}
+
+// NewMemory creates a new MemoryStore instance.
+func NewMemory() *MemoryStore {
+	return &MemoryStore{
+		index:         make(map[string]record),
+		defaultFields: bag{},
+	}
+}
+
+// Init does nothing, the store is always ready.
+func (s *MemoryStore) Init(ctx context.Context) error {
+	return nil
+}
+
+// AddDefaultField adds a default field to the store. These fields are added to all events.
+func (s *MemoryStore) AddDefaultField(k string, v interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.defaultFields[k] = v
+}
+
+// Append adds an event to the store.
+func (s *MemoryStore) Append(ctx context.Context, value IndexMarshaller) error {
+	ctx = trace.StartCall(ctx, "memory.Append")
+	defer trace.EndCall(ctx)
+
+	return trace.SetCallStatus(ctx, s.append(value, false, nil))
+}
+
+// append adds default fields to the event, and replaces the previous version of it.
+// The sinks the event was delivered to are added to those of the previous version of the event.
+func (s *MemoryStore) append(value IndexMarshaller, processed bool, delivered []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	val := make(map[string]interface{})
+
+	adder := addField(val)
+	s.defaultFields.MarshalRecord(adder)
+	value.MarshalRecord(adder)
+
+	// The round trip copies the data and gives it the JSON types, as if it was read from a log file.
+	b, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	r := record{key: value.Key(), seq: s.seq, processed: processed, data: data}
+	if len(delivered) > 0 {
+		r.delivered = mergeSinks(s.index[r.key].delivered, delivered)
+	}
+	r.timestamp, r.hasTimestamp = millis(data["timestamp"])
+	s.seq++
+	s.index[r.key] = r
+
+	return nil
+}
+
+// Get gets an event from the store.
+func (s *MemoryStore) Get(ctx context.Context, key string, value IndexMarshaller) error {
+	s.mu.Lock()
+	r, ok := s.index[key]
+	s.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return value.UnmarshalRecord(r.data)
+}
+
+// GetAll returns all the events in the store. The latest versions of the events.
+func (s *MemoryStore) GetAll(ctx context.Context) *Cursor {
+	return s.Query(ctx, Filter{})
+}
+
+// GetUnprocessed returns all the events in the store that have not been processed.
+func (s *MemoryStore) GetUnprocessed(ctx context.Context) *Cursor {
+	processed := false
+	return s.Query(ctx, Filter{Processed: &processed})
+}
+
+// Query returns the latest versions of the events matching the filter, in the order they were appended.
+func (s *MemoryStore) Query(ctx context.Context, f Filter) *Cursor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]record, 0, len(s.index))
+	for _, r := range s.index {
+		if f.matchRecord(&r) && f.matchData(r.data) {
+			records = append(records, r)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].seq < records[j].seq
+	})
+
+	items := make([]map[string]interface{}, len(records))
+	for i := range records {
+		items[i] = records[i].data
+	}
+
+	return NewCursor(items)
+}
+
+// MarkProcessed marks the events as processed.
+func (s *MemoryStore) MarkProcessed(ctx context.Context, recs []IndexMarshaller) error {
+	ctx = trace.StartCall(ctx, "memory.MarkProcessed")
+	defer trace.EndCall(ctx)
+
+	for _, rec := range recs {
+		if err := s.append(rec, true, nil); err != nil {
+			return trace.SetCallStatus(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+// MarkDelivered records that the events were delivered to the named sink.
+func (s *MemoryStore) MarkDelivered(ctx context.Context, sink string, recs []IndexMarshaller) error {
+	ctx = trace.StartCall(ctx, "memory.MarkDelivered")
+	defer trace.EndCall(ctx)
+
+	for _, rec := range recs {
+		if err := s.append(rec, false, []string{sink}); err != nil {
+			return trace.SetCallStatus(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+// Lock acquires an exclusive lock on the store. It only excludes the other users within the process.
+func (s *MemoryStore) Lock(ctx context.Context) (func(), error) {
+	s.lock.Lock()
+	return s.lock.Unlock, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+
+	s.AddDefaultField("branch", "main")
+	assert.NoError(t, s.Append(ctx, &payload{ID: "1", Content: "one"}))
+	assert.NoError(t, s.Append(ctx, &payload{ID: "2", Content: "two"}))
+	assert.NoError(t, s.Append(ctx, &payload{ID: "1", Content: "one again"}))
+
+	var p payload
+	assert.NoError(t, s.Get(ctx, "1", &p))
+	assert.Equal(t, "one again", p.Content)
+
+	e := event{}
+	c := s.GetAll(ctx)
+	assert.Equal(t, 2, c.Len())
+	assert.True(t, c.Next())
+	assert.NoError(t, c.Value(e))
+	assert.Equal(t, "2", e["id"])
+	assert.Equal(t, "main", e["branch"])
+
+	unlock, err := s.Lock(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, s.MarkProcessed(ctx, []IndexMarshaller{&payload{ID: "2", Content: "two"}}))
+	unlock()
+
+	c = s.GetUnprocessed(ctx)
+	assert.Equal(t, 1, c.Len())
+	assert.True(t, c.Next())
+	assert.NoError(t, c.Value(&p))
+	assert.Equal(t, "1", p.ID)
+}
+
+func TestMemoryQuery(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemory()
+
+	base := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	for _, e := range []event{
+		{"hook": "before:deploy", "execution_id": "1", "status": "info", "timestamp": base.UnixMilli()},
+		{"hook": "error:deploy", "execution_id": "1", "status": "error", "timestamp": base.Add(time.Minute).UnixMilli(),
+			"duration_ms": 42},
+	} {
+		assert.NoError(t, s.Append(ctx, e))
+	}
+
+	assert.Equal(t, []string{"1_error:deploy"}, queryKeys(t, s.Query(ctx, Filter{Since: base.Add(time.Second)})))
+	assert.Equal(t, []string{"1_error:deploy"}, queryKeys(t, s.Query(ctx, Filter{
+		Fields: map[string]interface{}{"duration_ms": 42},
+	})))
+	assert.Equal(t, []string{"1_before:deploy"}, queryKeys(t, s.Query(ctx, Filter{HookPrefix: "before:"})))
+}
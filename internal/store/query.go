@@ -0,0 +1,155 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the query filter for selecting events from the store.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/getoutreach/gobox/pkg/log"
+	"github.com/getoutreach/gobox/pkg/trace"
+)
+
+// Filter selects the events returned by Query. The zero value of a field doesn't filter.
+type Filter struct {
+	// Since and Until limit the timestamp field of the events. Since is inclusive, Until is exclusive.
+	// Events without timestamp don't match when either is set.
+	Since time.Time
+	Until time.Time
+
+	// HookPrefix matches the hook field by prefix.
+	HookPrefix  string
+	ExecutionID string
+	Status      string
+
+	// Processed matches whether the events were processed.
+	Processed *bool
+	// Undelivered matches the events that were not delivered to the named sink.
+	Undelivered string
+
+	// Fields matches the event fields by equality. Nested fields are addressed by dotted path, e.g. command.name.
+	Fields map[string]interface{}
+}
+
+// matchRecord matches the parts of the filter that are known without reading the event.
+func (f *Filter) matchRecord(r *record) bool {
+	if f.Processed != nil && *f.Processed != r.processed {
+		return false
+	}
+	if f.Undelivered != "" && delivered(r.delivered, f.Undelivered) {
+		return false
+	}
+
+	if f.Since.IsZero() && f.Until.IsZero() {
+		return true
+	}
+	if !r.hasTimestamp {
+		return false
+	}
+	if !f.Since.IsZero() && r.timestamp < f.Since.UnixMilli() {
+		return false
+	}
+	if !f.Until.IsZero() && r.timestamp >= f.Until.UnixMilli() {
+		return false
+	}
+
+	return true
+}
+
+// needsData returns true if the filter matches event fields.
+func (f *Filter) needsData() bool {
+	return f.HookPrefix != "" || f.ExecutionID != "" || f.Status != "" || len(f.Fields) > 0
+}
+
+// matchData matches the event fields.
+func (f *Filter) matchData(data map[string]interface{}) bool {
+	if f.HookPrefix != "" {
+		hook, ok := data["hook"].(string)
+		if !ok || !strings.HasPrefix(hook, f.HookPrefix) {
+			return false
+		}
+	}
+	if f.ExecutionID != "" && data["execution_id"] != f.ExecutionID {
+		return false
+	}
+	if f.Status != "" && data["status"] != f.Status {
+		return false
+	}
+
+	for path, expected := range f.Fields {
+		v, ok := lookupField(data, strings.Split(path, "."))
+		if !ok || !equalValues(v, expected) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lookupField returns the value at path in nested maps.
+func lookupField(m map[string]interface{}, path []string) (interface{}, bool) {
+	v, ok := m[path[0]]
+	if !ok || len(path) == 1 {
+		return v, ok
+	}
+
+	nested, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return lookupField(nested, path[1:])
+}
+
+// equalValues compares the values by their JSON encoding. The events read from the log files hold the JSON
+// types (float64 for numbers), while the filter values are usually Go types.
+func equalValues(a, b interface{}) bool {
+	ab, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+
+	return string(ab) == string(bb)
+}
+
+// Query returns the latest versions of the events matching the filter.
+// The events are read once to match the filter and again as the cursor is iterated.
+func (s *FSStore) Query(ctx context.Context, f Filter) *Cursor {
+	ctx = trace.StartCall(ctx, "store.Query")
+	defer trace.EndCall(ctx)
+
+	records := s.latest(f.matchRecord)
+	if !f.needsData() {
+		return s.cursor(records)
+	}
+
+	rr := recordReader{fsys: s.logFS}
+	//nolint:errcheck // Why: The files are only read.
+	defer rr.Close()
+
+	var unreadable int
+	matched := records[:0]
+	for i := range records {
+		e, err := rr.entry(&records[i])
+		if err != nil {
+			unreadable++
+			continue
+		}
+		if f.matchData(e.Data) {
+			matched = append(matched, records[i])
+		}
+	}
+
+	if unreadable > 0 {
+		trace.AddInfo(ctx, log.F{"store.unreadable_entries": unreadable})
+	}
+
+	return s.cursor(matched)
+}
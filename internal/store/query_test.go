@@ -0,0 +1,89 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// event is a test event with the fields used by Filter.
+type event map[string]interface{}
+
+func (e event) Key() string {
+	return e["execution_id"].(string) + "_" + e["hook"].(string)
+}
+
+func (e event) MarshalRecord(addField func(name string, value interface{})) {
+	for k, v := range e {
+		addField(k, v)
+	}
+}
+
+func (e event) UnmarshalRecord(data map[string]interface{}) error {
+	for k, v := range data {
+		e[k] = v
+	}
+	return nil
+}
+
+func queryKeys(t *testing.T, c *Cursor) []string {
+	var keys []string
+	for c.Next() {
+		e := event{}
+		assert.NoError(t, c.Value(e))
+		keys = append(keys, e.Key())
+	}
+	return keys
+}
+
+func TestQuery(t *testing.T) {
+	s := New(&Options{LogDir: t.TempDir()})
+	assert.NoError(t, s.Init(context.Background()))
+
+	base := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	events := []event{
+		{"hook": "before:deploy", "execution_id": "1", "status": "info", "timestamp": base.UnixMilli(),
+			"command": map[string]interface{}{"name": "deploy"}},
+		{"hook": "after:deploy", "execution_id": "1", "status": "info", "timestamp": base.Add(time.Minute).UnixMilli(),
+			"command": map[string]interface{}{"name": "deploy"}},
+		{"hook": "before:build", "execution_id": "2", "status": "info", "timestamp": base.Add(time.Hour).UnixMilli(),
+			"command": map[string]interface{}{"name": "build"}},
+		{"hook": "error:build", "execution_id": "2", "status": "error", "timestamp": base.Add(2 * time.Hour).UnixMilli(),
+			"command": map[string]interface{}{"name": "build"}, "duration_ms": 42},
+	}
+	for _, e := range events {
+		assert.NoError(t, s.Append(context.Background(), e))
+	}
+	assert.NoError(t, s.MarkProcessed(context.Background(), []IndexMarshaller{events[0]}))
+
+	processed := true
+	unprocessed := false
+	tests := []struct {
+		name   string
+		filter Filter
+		keys   []string
+	}{
+		{"all", Filter{}, []string{"1_after:deploy", "2_before:build", "2_error:build", "1_before:deploy"}},
+		{"time range", Filter{Since: base.Add(time.Minute), Until: base.Add(2 * time.Hour)},
+			[]string{"1_after:deploy", "2_before:build"}},
+		{"hook prefix", Filter{HookPrefix: "before:"}, []string{"2_before:build", "1_before:deploy"}},
+		{"execution id", Filter{ExecutionID: "2"}, []string{"2_before:build", "2_error:build"}},
+		{"status", Filter{Status: "error"}, []string{"2_error:build"}},
+		{"processed", Filter{Processed: &processed}, []string{"1_before:deploy"}},
+		{"unprocessed", Filter{Processed: &unprocessed, ExecutionID: "1"}, []string{"1_after:deploy"}},
+		{"nested field", Filter{Fields: map[string]interface{}{"command.name": "deploy"}},
+			[]string{"1_after:deploy", "1_before:deploy"}},
+		{"numeric field", Filter{Fields: map[string]interface{}{"duration_ms": 42}}, []string{"2_error:build"}},
+		{"no match", Filter{Fields: map[string]interface{}{"command.name.x": "deploy"}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.keys, queryKeys(t, s.Query(context.Background(), tt.filter)))
+		})
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the repair of log files damaged by crashed or killed writers.
+
+package store
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// repair rewrites the damaged log files without the corrupt lines.
+// The original file is kept next to it as .<name>.corrupt, so that it's not restored again, but can be inspected.
+func (s *FSStore) repair() error {
+	if !s.managed || len(s.damaged) == 0 {
+		return nil
+	}
+
+	if err := s.locker.Lock(); err != nil {
+		return errors.Wrap(err, "failed to lock log dir")
+	}
+	//nolint:errcheck // Why: The lock is released with the lock file being closed.
+	defer s.locker.Unlock()
+
+	// Other processes could have appended to the files since we've restored them.
+	if err := s.refresh(); err != nil {
+		return err
+	}
+
+	for name := range s.damaged {
+		if err := s.repairFile(name); err != nil {
+			return errors.Wrapf(err, "failed to repair %s", name)
+		}
+		delete(s.damaged, name)
+	}
+
+	// The records point to the offsets in the damaged files.
+	return s.reload()
+}
+
+// repairFile replaces the log file with a copy that contains only the valid lines.
+func (s *FSStore) repairFile(name string) error {
+	path := filepath.Join(s.logDir, name)
+	dir, base := filepath.Split(path)
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var good bytes.Buffer
+	for _, line := range bytes.SplitAfter(b, []byte("\n")) {
+		// The last line without a newline is an incomplete write, it's dropped too.
+		if validLine(line) {
+			good.Write(line)
+		}
+	}
+
+	tmpPath := filepath.Join(dir, "."+base+".tmp")
+	if err := os.WriteFile(tmpPath, good.Bytes(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(path, filepath.Join(dir, "."+base+".corrupt")); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// truncateIncomplete truncates the current log file to the last complete line.
+// The incomplete line is left behind by a writer that was killed, appending after it would corrupt the next line.
+func (s *FSStore) truncateIncomplete() error {
+	if !s.managed || s.offsets == nil {
+		return nil
+	}
+
+	info, err := os.Stat(s.logPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if info.Size() <= s.offsets[s.logName] {
+		return nil
+	}
+
+	s.corrupt++
+	return os.Truncate(s.logPath, s.offsets[s.logName])
+}
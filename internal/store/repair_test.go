@@ -0,0 +1,132 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreSkipsCorruptLines(t *testing.T) {
+	logFS := make(fstest.MapFS)
+	logFS["1.log"] = &fstest.MapFile{
+		Data: []byte(`{"key":"before:deploy","data":{"id":"before:deploy"}}` + "\n" +
+			`{"key":"after:dep` + "\n" +
+			`{"key":"after:deploy","data":{"id":"after:deploy"}}` + "\n"),
+	}
+
+	var buff TestClosableBuffer
+	s := New(&Options{
+		LogFS:      logFS,
+		OpenAppend: openAppender(&buff),
+	})
+
+	assert.NoError(t, s.Init(context.Background()))
+	assert.Equal(t, 1, s.corrupt)
+	assert.Equal(t, 2, s.GetAll(context.Background()).Len())
+}
+
+func TestInitRepairsDamagedFiles(t *testing.T) {
+	dir := t.TempDir()
+	good := `{"key":"before:deploy","data":{"id":"before:deploy"}}` + "\n"
+	damaged := good +
+		`{"key":"after:dep{"key":"after:build","data":{"id":"after:build"}}` + "\n" +
+		`{"key":"after:deploy","data":{"id":"after:deploy"}}` + "\n" +
+		`{"key":"after:purge","da`
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "1.log"), []byte(damaged), 0o600))
+
+	s := New(&Options{LogDir: dir})
+	assert.NoError(t, s.Init(context.Background()))
+	assert.Equal(t, 2, s.GetAll(context.Background()).Len())
+
+	b, err := os.ReadFile(filepath.Join(dir, "1.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, good+`{"key":"after:deploy","data":{"id":"after:deploy"}}`+"\n", string(b))
+
+	b, err = os.ReadFile(filepath.Join(dir, ".1.log.corrupt"))
+	assert.NoError(t, err)
+	assert.Equal(t, damaged, string(b))
+
+	assert.NoError(t, s.Append(context.Background(), &payload{ID: "after:build"}))
+
+	restored := New(&Options{LogDir: dir})
+	assert.NoError(t, restored.Init(context.Background()))
+	assert.Equal(t, 0, restored.corrupt)
+	assert.Equal(t, 3, restored.GetAll(context.Background()).Len())
+}
+
+func TestAppendTruncatesIncompleteLine(t *testing.T) {
+	dir := t.TempDir()
+	s := New(&Options{LogDir: dir})
+	assert.NoError(t, s.Init(context.Background()))
+	assert.NoError(t, s.Append(context.Background(), &payload{ID: "before:deploy"}))
+
+	f, err := os.OpenFile(s.logPath, os.O_APPEND|os.O_WRONLY, 0o600)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"key":"after:dep`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	assert.NoError(t, s.Append(context.Background(), &payload{ID: "after:deploy"}))
+
+	restored := New(&Options{LogDir: dir})
+	assert.NoError(t, restored.Init(context.Background()))
+	assert.Equal(t, 0, restored.corrupt)
+	assert.Equal(t, 2, restored.GetAll(context.Background()).Len())
+}
+
+func TestRefreshRestoresFilesRepairedByOtherProcesses(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := New(&Options{LogDir: dir})
+	assert.NoError(t, s.Init(ctx))
+	assert.NoError(t, s.Append(ctx, &payload{ID: "before:deploy"}))
+
+	// A crashed writer leaves a corrupt line behind, which the store restores past.
+	f, err := os.OpenFile(s.logPath, os.O_APPEND|os.O_WRONLY, 0o600)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"key":"after:dep` + "\n" + `{"key":"after:deploy","data":{"id":"after:deploy"}}` + "\n")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	unlock, err := s.Lock(ctx)
+	assert.NoError(t, err)
+	unlock()
+
+	// Another process repairs the file, shifting the entries.
+	assert.NoError(t, New(&Options{LogDir: dir}).Init(ctx))
+
+	assert.NoError(t, s.Append(ctx, &payload{ID: "after:build"}))
+	for _, id := range []string{"before:deploy", "after:deploy", "after:build"} {
+		var p payload
+		assert.NoError(t, s.Get(ctx, id, &p))
+		assert.Equal(t, id, p.ID)
+	}
+	assert.Equal(t, 3, s.GetAll(ctx).Len())
+}
+
+func TestRefreshReloadsTruncatedFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := New(&Options{LogDir: dir})
+	assert.NoError(t, s.Init(ctx))
+	assert.NoError(t, s.Append(ctx, &payload{ID: "before:deploy", Content: "a long line the truncated file is shorter than"}))
+
+	// The file is truncated in place, the restored offset is past its end.
+	assert.NoError(t, os.Truncate(s.logPath, 0))
+	assert.NoError(t, os.WriteFile(s.logPath, []byte(`{"key":"after:deploy","data":{"id":"after:deploy"}}`+"\n"), 0o600))
+
+	unlock, err := s.Lock(ctx)
+	assert.NoError(t, err)
+	unlock()
+
+	var p payload
+	assert.NoError(t, s.Get(ctx, "after:deploy", &p))
+	assert.Equal(t, "after:deploy", p.ID)
+	assert.Equal(t, 1, s.GetAll(ctx).Len())
+}
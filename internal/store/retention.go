@@ -0,0 +1,151 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the retention policy of the log files.
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/getoutreach/gobox/pkg/log"
+	"github.com/getoutreach/gobox/pkg/trace"
+	"github.com/pkg/errors"
+)
+
+// Collector is implemented by the stores that enforce the retention policy.
+type Collector interface {
+	// GC removes the data over the retention policy and returns it, with dryRun it only returns the data
+	// that would be removed.
+	GC(ctx context.Context, dryRun bool) ([]Segment, error)
+}
+
+// setRetentionDefaults sets the default retention policy of the options.
+func setRetentionDefaults(opts *Options) {
+	if opts.RetentionMaxAge == 0 {
+		opts.RetentionMaxAge = 30 * 24 * time.Hour
+	}
+
+	if opts.RetentionMaxBytes == 0 {
+		opts.RetentionMaxBytes = 64 << 20
+	}
+}
+
+// Segment describes the data the retention policy applies to, a log file in the log dir of FSStore,
+// or an entry of SQLiteStore.
+type Segment struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// GC removes the log files over the retention policy. These are the files that were not modified for longer
+// than the retention max age, and the oldest files while the log dir is over the retention max bytes.
+// The last log file is never removed for size. Quarantined corrupt files are subject to the policy as well.
+// It returns the removed files, with dryRun it only returns the files that would be removed.
+func (s *FSStore) GC(ctx context.Context, dryRun bool) ([]Segment, error) {
+	ctx = trace.StartCall(ctx, "store.GC")
+	defer trace.EndCall(ctx)
+
+	if !s.managed {
+		return nil, trace.SetCallStatus(ctx, fmt.Errorf("gc requires the store to manage the log dir"))
+	}
+
+	if err := s.locker.Lock(); err != nil {
+		return nil, trace.SetCallStatus(ctx, errors.Wrap(err, "failed to lock log dir"))
+	}
+	//nolint:errcheck // Why: The lock is released with the lock file being closed.
+	defer s.locker.Unlock()
+
+	segments, err := s.segments()
+	if err != nil {
+		return nil, trace.SetCallStatus(ctx, err)
+	}
+
+	expired := expiredSegments(segments, time.Now(), s.retentionMaxAge, s.retentionMaxBytes)
+	trace.AddInfo(ctx, log.F{"store.expired_segments": len(expired), "store.dry_run": dryRun})
+	if dryRun {
+		return expired, trace.SetCallStatus(ctx, nil)
+	}
+
+	for _, seg := range expired {
+		if err := os.Remove(filepath.Join(s.logDir, seg.Name)); err != nil && !os.IsNotExist(err) {
+			return nil, trace.SetCallStatus(ctx, errors.Wrapf(err, "failed to remove %s", seg.Name))
+		}
+	}
+
+	// The older versions of the entries in the remaining files are the latest ones now.
+	if len(expired) > 0 {
+		if err := s.reload(); err != nil {
+			return nil, trace.SetCallStatus(ctx, err)
+		}
+	}
+
+	return expired, trace.SetCallStatus(ctx, nil)
+}
+
+// segments lists the log files and quarantined corrupt files in the log dir, sorted by name. Corrupt files sort
+// first, as they start with a dot, and the log files are named by their creation time.
+func (s *FSStore) segments() ([]Segment, error) {
+	var segments []Segment
+	err := fs.WalkDir(s.logFS, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") && !strings.HasSuffix(d.Name(), ".corrupt") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		segments = append(segments, Segment{Name: path, Size: info.Size(), ModTime: info.ModTime()})
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list log dir")
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Name < segments[j].Name
+	})
+
+	return segments, nil
+}
+
+// expiredSegments returns the segments over the retention policy of maxAge and maxBytes. The segments are ordered
+// from the oldest, the last one is never expired for size.
+func expiredSegments(segments []Segment, now time.Time, maxAge time.Duration, maxBytes int64) []Segment {
+	var expired, kept []Segment
+	var size int64
+	for _, seg := range segments {
+		if maxAge > 0 && now.Sub(seg.ModTime) > maxAge {
+			expired = append(expired, seg)
+			continue
+		}
+		kept = append(kept, seg)
+		size += seg.Size
+	}
+
+	if maxBytes <= 0 {
+		return expired
+	}
+
+	for i := 0; i < len(kept)-1 && size > maxBytes; i++ {
+		expired = append(expired, kept[i])
+		size -= kept[i].Size
+	}
+
+	return expired
+}
@@ -0,0 +1,61 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCRemovesExpiredSegments(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "1.log"), []byte(`{"key":"a","data":{"id":"a"}}`+"\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "2.log"), []byte(`{"key":"b","data":{"id":"b"}}`+"\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, ".1.log.corrupt"), []byte(`{"key":"a`+"\n"), 0o600))
+
+	old := time.Now().Add(-48 * time.Hour)
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "1.log"), old, old))
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, ".1.log.corrupt"), old, old))
+
+	s := New(&Options{LogDir: dir, RetentionMaxAge: 24 * time.Hour})
+	assert.NoError(t, s.Init(context.Background()))
+	assert.FileExists(t, filepath.Join(dir, "1.log"), "Init leaves the retention to GC")
+
+	expired, err := s.GC(context.Background(), false)
+	assert.NoError(t, err)
+	assert.Len(t, expired, 2)
+
+	assert.NoFileExists(t, filepath.Join(dir, "1.log"))
+	assert.NoFileExists(t, filepath.Join(dir, ".1.log.corrupt"))
+	assert.FileExists(t, filepath.Join(dir, "2.log"))
+
+	var a payload
+	assert.NoError(t, s.Get(context.Background(), "a", &a))
+	assert.Empty(t, a)
+	assert.Equal(t, 1, s.GetAll(context.Background()).Len())
+}
+
+func TestGCRemovesOldestOverMaxBytes(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"1.log", "2.log", "3.log"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(`{"key":"`+name+`","data":{}}`+"\n"), 0o600))
+	}
+
+	s := New(&Options{LogDir: dir, RetentionMaxBytes: 40})
+
+	expired, err := s.GC(context.Background(), true)
+	assert.NoError(t, err)
+	assert.Len(t, expired, 2)
+	assertLogFiles(t, dir, 3)
+
+	expired, err = s.GC(context.Background(), false)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.log", expired[0].Name)
+	assert.Equal(t, "2.log", expired[1].Name)
+	assertLogFiles(t, dir, 1)
+}
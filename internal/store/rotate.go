@@ -0,0 +1,113 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the log rotation.
+
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// rotate starts a new log file when the current one reached the segment limits.
+// The old log files that hold only superseded or expired processed entries are removed.
+func (s *FSStore) rotate() error {
+	if !s.shouldRotate() {
+		return nil
+	}
+
+	s.logName = s.newLogName()
+	s.logPath = filepath.Join(s.logDir, s.logName)
+	s.lastName = s.logName
+
+	return s.removeProcessedSegments()
+}
+
+// shouldRotate checks the current log file against the segment limits.
+func (s *FSStore) shouldRotate() bool {
+	if s.offsets == nil {
+		return false
+	}
+
+	size, ok := s.offsets[s.logName]
+	if !ok {
+		// The log file doesn't exist yet.
+		return false
+	}
+
+	if s.maxSegmentBytes > 0 && size >= s.maxSegmentBytes {
+		return true
+	}
+	if s.maxSegmentEntries > 0 && s.segmentEntries[s.logName] >= s.maxSegmentEntries {
+		return true
+	}
+	if s.maxSegmentAge > 0 {
+		if created, ok := segmentTime(s.logName); ok && time.Since(created) >= s.maxSegmentAge {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removeProcessedSegments removes the log files, other than the current one, in which every entry is either
+// superseded by an entry in another file, or processed and older than the processed retention.
+func (s *FSStore) removeProcessedSegments() error {
+	if !s.managed {
+		return nil
+	}
+
+	removable := make(map[string]bool, len(s.offsets))
+	for name := range s.offsets {
+		if name != s.logName {
+			removable[name] = true
+		}
+	}
+
+	cutoff := time.Now().Add(-s.processedRetention).UnixMilli()
+	for key := range s.index {
+		r := s.index[key]
+		if removable[r.file] && !r.expired(cutoff) {
+			removable[r.file] = false
+		}
+	}
+
+	for name, ok := range removable {
+		if !ok {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.logDir, name)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to remove %s", name)
+		}
+		delete(s.offsets, name)
+		delete(s.files, name)
+		s.dropFiles(map[string]bool{name: true})
+	}
+
+	return nil
+}
+
+// segmentTime returns the time the log file was created at, based on its name.
+// The names are unix timestamps, either in seconds or nanoseconds.
+func segmentTime(name string) (time.Time, bool) {
+	name = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	if i := strings.IndexByte(name, '_'); i >= 0 {
+		name = name[:i]
+	}
+
+	v, err := strconv.ParseInt(name, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	// Nanosecond timestamps have 19 digits, second ones 10.
+	if v < 1e12 {
+		return time.Unix(v, 0), true
+	}
+	return time.Unix(0, v), true
+}
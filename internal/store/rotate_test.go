@@ -0,0 +1,65 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotateBySegmentEntries(t *testing.T) {
+	dir := t.TempDir()
+	s := New(&Options{LogDir: dir, MaxSegmentEntries: 2})
+	assert.NoError(t, s.Init(context.Background()))
+
+	assert.NoError(t, s.Append(context.Background(), &payload{ID: "a"}))
+	assert.NoError(t, s.Append(context.Background(), &payload{ID: "b"}))
+	first := s.logName
+
+	assert.NoError(t, s.MarkProcessed(context.Background(), []IndexMarshaller{&payload{ID: "a"}, &payload{ID: "b"}}))
+	assert.NotEqual(t, first, s.logName)
+	assertLogFiles(t, dir, 2)
+
+	// The first segment holds only superseded entries now, it's removed on next rotation.
+	assert.NoError(t, s.Append(context.Background(), &payload{ID: "c"}))
+	assertLogFiles(t, dir, 2)
+	assert.NotContains(t, s.offsets, first)
+
+	restored := New(&Options{LogDir: dir})
+	assert.NoError(t, restored.Init(context.Background()))
+	assert.Equal(t, 3, restored.GetAll(context.Background()).Len())
+	assert.Equal(t, 1, restored.GetUnprocessed(context.Background()).Len())
+}
+
+func TestRotateBySegmentBytes(t *testing.T) {
+	dir := t.TempDir()
+	s := New(&Options{LogDir: dir, MaxSegmentBytes: 10})
+	assert.NoError(t, s.Init(context.Background()))
+
+	assert.NoError(t, s.Append(context.Background(), &payload{ID: "a"}))
+	assert.NoError(t, s.Append(context.Background(), &payload{ID: "b"}))
+	assertLogFiles(t, dir, 2)
+}
+
+func TestSegmentTime(t *testing.T) {
+	created, ok := segmentTime("1651388142.log")
+	assert.True(t, ok)
+	assert.Equal(t, time.Unix(1651388142, 0), created)
+
+	created, ok = segmentTime("1651388142703000000_1.log")
+	assert.True(t, ok)
+	assert.Equal(t, time.Unix(0, 1651388142703000000), created)
+
+	_, ok = segmentTime("test1.txt")
+	assert.False(t, ok)
+}
+
+func assertLogFiles(t *testing.T, dir string, count int) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	assert.NoError(t, err)
+	assert.Len(t, files, count)
+}
@@ -0,0 +1,617 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the SQLite backed implementation of Store.
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/getoutreach/gobox/pkg/log"
+	"github.com/getoutreach/gobox/pkg/trace"
+	"github.com/pkg/errors"
+
+	// Registers the pure Go sqlite driver.
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema is the schema of the SQLite store. The seq column orders the entries by their latest append.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS entries (
+	key        TEXT    NOT NULL PRIMARY KEY,
+	data       TEXT    NOT NULL,
+	processed  INTEGER NOT NULL DEFAULT 0,
+	timestamp  INTEGER,
+	seq        INTEGER NOT NULL,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS entries_seq ON entries (seq);
+CREATE INDEX IF NOT EXISTS entries_processed ON entries (processed, seq);
+CREATE INDEX IF NOT EXISTS entries_timestamp ON entries (timestamp);
+CREATE TABLE IF NOT EXISTS migrations (
+	name        TEXT    NOT NULL PRIMARY KEY,
+	migrated_at INTEGER NOT NULL
+);
+`
+
+// sqliteMigrations are the changes to the schema, applied in order once per database.
+var sqliteMigrations = []struct {
+	name string
+	stmt string
+}{
+	// delivered holds the JSON list of the sinks the unprocessed entry was delivered to.
+	{name: "delivered", stmt: "ALTER TABLE entries ADD COLUMN delivered TEXT"},
+}
+
+// upsertEntry inserts an entry or replaces the previous version of it.
+const upsertEntry = `
+INSERT INTO entries (key, data, processed, delivered, timestamp, seq, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, (SELECT COALESCE(MAX(seq), 0) + 1 FROM entries), ?, ?)
+ON CONFLICT (key) DO UPDATE SET
+	data = excluded.data,
+	processed = excluded.processed,
+	delivered = excluded.delivered,
+	timestamp = excluded.timestamp,
+	seq = excluded.seq,
+	updated_at = excluded.updated_at
+`
+
+// jsonlMigration is the name of the migration of the JSONL log files into the SQLite store.
+const jsonlMigration = "jsonl"
+
+// SQLiteStore is the implementation of Store backed by an SQLite database file.
+// On Init, the entries in the JSONL log files in the log dir are migrated into the database once.
+type SQLiteStore struct {
+	path   string
+	logDir string
+	locker Locker
+
+	retentionMaxAge   time.Duration
+	retentionMaxBytes int64
+
+	db            *sql.DB
+	defaultFields bag
+}
+
+// NewSQLite creates a new SQLiteStore instance. The database is opened on Init.
+func NewSQLite(opts *Options) *SQLiteStore {
+	if opts.LogDir == "" {
+		opts.LogDir = DefaultLogDir()
+	}
+
+	if opts.SQLitePath == "" {
+		// The dot prefix keeps the JSONL store from restoring it as a log file.
+		opts.SQLitePath = filepath.Join(opts.LogDir, ".devtel.db")
+	}
+
+	if opts.Locker == nil {
+		opts.Locker = NewFileLocker(opts.SQLitePath + ".lock")
+	}
+
+	setRetentionDefaults(opts)
+
+	return &SQLiteStore{
+		path:              opts.SQLitePath,
+		logDir:            opts.LogDir,
+		locker:            opts.Locker,
+		retentionMaxAge:   opts.RetentionMaxAge,
+		retentionMaxBytes: opts.RetentionMaxBytes,
+		defaultFields:     bag{},
+	}
+}
+
+// Init opens the database, creates and migrates the schema, and migrates the JSONL log files.
+func (s *SQLiteStore) Init(ctx context.Context) error {
+	ctx = trace.StartCall(ctx, "sqlite.Init")
+	defer trace.EndCall(ctx)
+
+	if s.db != nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return trace.SetCallStatus(ctx, err)
+	}
+
+	db, err := sql.Open("sqlite", s.path)
+	if err != nil {
+		return trace.SetCallStatus(ctx, errors.Wrap(err, "failed to open database"))
+	}
+	// A single connection serializes the statements within the process, SQLite serializes the processes.
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range []string{"PRAGMA busy_timeout = 5000", "PRAGMA journal_mode = WAL", sqliteSchema} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			db.Close()
+			return trace.SetCallStatus(ctx, errors.Wrap(err, "failed to initialize database"))
+		}
+	}
+	s.db = db
+
+	// The store is left uninitialized when the migrations fail, so that the next Init applies them again.
+	if err := s.migrateAll(ctx); err != nil {
+		db.Close()
+		s.db = nil
+		return trace.SetCallStatus(ctx, err)
+	}
+
+	return nil
+}
+
+// migrateAll applies the schema migrations, and migrates the JSONL log files, under the lock.
+func (s *SQLiteStore) migrateAll(ctx context.Context) error {
+	// The migrations are applied by one process at a time.
+	if err := s.locker.Lock(); err != nil {
+		return errors.Wrap(err, "failed to lock database")
+	}
+	//nolint:errcheck // Why: The lock is released with the lock file being closed.
+	defer s.locker.Unlock()
+
+	if err := s.migrateSchema(ctx); err != nil {
+		return err
+	}
+
+	return s.migrateJSONL(ctx)
+}
+
+// migrateSchema applies the schema migrations that were not applied to the database yet.
+func (s *SQLiteStore) migrateSchema(ctx context.Context) error {
+	for _, m := range sqliteMigrations {
+		if err := s.migrate(ctx, m.name, m.stmt); err != nil {
+			return errors.Wrapf(err, "failed to apply migration %s", m.name)
+		}
+	}
+
+	return nil
+}
+
+// migrate applies the statement of the named migration, unless it was applied already.
+func (s *SQLiteStore) migrate(ctx context.Context, name, stmt string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck // Why: Rollback fails after commit.
+	defer tx.Rollback()
+
+	var migrated int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM migrations WHERE name = ?", name).Scan(&migrated); err != nil {
+		return err
+	}
+	if migrated > 0 {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO migrations (name, migrated_at) VALUES (?, ?)",
+		name, time.Now().UnixMilli()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// migrateJSONL copies the latest versions of the entries in the JSONL log files into the database.
+// It's done once, the log files are left in place as they are.
+func (s *SQLiteStore) migrateJSONL(ctx context.Context) error {
+	var migrated int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM migrations WHERE name = ?", jsonlMigration).Scan(&migrated)
+	if err != nil {
+		return errors.Wrap(err, "failed to check migrations")
+	}
+	if migrated > 0 {
+		return nil
+	}
+
+	if _, err := os.Stat(s.logDir); err != nil {
+		if os.IsNotExist(err) {
+			return s.markMigrated(ctx, s.db)
+		}
+		return err
+	}
+
+	// The log files are only restored, Init would repair, collect and compact the files of the JSONL stores
+	// that might still use them.
+	fsStore := New(&Options{LogDir: s.logDir})
+	if err := fsStore.load(); err != nil {
+		return errors.Wrap(err, "failed to restore log files")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck // Why: Rollback fails after commit.
+	defer tx.Rollback()
+
+	rr := recordReader{fsys: fsStore.logFS}
+	//nolint:errcheck // Why: The files are only read.
+	defer rr.Close()
+
+	records := fsStore.latest(nil)
+	for i := range records {
+		e, err := rr.entry(&records[i])
+		if err != nil {
+			continue
+		}
+		if err := s.upsert(ctx, tx, e); err != nil {
+			return errors.Wrapf(err, "failed to migrate %s", e.Key)
+		}
+	}
+
+	if err := s.markMigrated(ctx, tx); err != nil {
+		return err
+	}
+
+	trace.AddInfo(ctx, log.F{"sqlite.migrated_entries": len(records)})
+
+	return tx.Commit()
+}
+
+// markMigrated records that the JSONL log files were migrated.
+func (s *SQLiteStore) markMigrated(ctx context.Context, db execer) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO migrations (name, migrated_at) VALUES (?, ?)",
+		jsonlMigration, time.Now().UnixMilli())
+	return errors.Wrap(err, "failed to record migration")
+}
+
+// execer is implemented by both sql.DB and sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// upsert inserts the entry, superseding the previous version of it.
+func (s *SQLiteStore) upsert(ctx context.Context, db execer, e entry) error {
+	b, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+
+	var timestamp sql.NullInt64
+	timestamp.Int64, timestamp.Valid = millis(e.Data["timestamp"])
+
+	var delivered sql.NullString
+	if len(e.Delivered) > 0 {
+		d, err := json.Marshal(e.Delivered)
+		if err != nil {
+			return err
+		}
+		delivered.String, delivered.Valid = string(d), true
+	}
+
+	now := time.Now().UnixMilli()
+	_, err = db.ExecContext(ctx, upsertEntry, e.Key, string(b), e.Processed, delivered, timestamp, now, now)
+	return err
+}
+
+// Close closes the database.
+func (s *SQLiteStore) Close() error {
+	if s.db == nil {
+		return nil
+	}
+
+	db := s.db
+	s.db = nil
+	return db.Close()
+}
+
+// AddDefaultField adds a default field to the store. These fields are added to all events.
+func (s *SQLiteStore) AddDefaultField(k string, v interface{}) {
+	s.defaultFields[k] = v
+}
+
+// Append adds an event to the store.
+func (s *SQLiteStore) Append(ctx context.Context, value IndexMarshaller) error {
+	ctx = trace.StartCall(ctx, "sqlite.Append")
+	defer trace.EndCall(ctx)
+
+	return trace.SetCallStatus(ctx, s.append(ctx, value, false, nil))
+}
+
+// append adds default fields to the event, and upserts it.
+// The sinks the event was delivered to are added to those of the previous version of the event.
+func (s *SQLiteStore) append(ctx context.Context, value IndexMarshaller, processed bool, delivered []string) error {
+	if s.db == nil {
+		return fmt.Errorf("store is not initialized")
+	}
+
+	val := make(map[string]interface{})
+
+	adder := addField(val)
+	s.defaultFields.MarshalRecord(adder)
+	value.MarshalRecord(adder)
+
+	e := entry{Key: value.Key(), Data: val, Processed: processed}
+	if len(delivered) > 0 {
+		previous, err := s.delivered(ctx, e.Key)
+		if err != nil {
+			return err
+		}
+		e.Delivered = mergeSinks(previous, delivered)
+	}
+
+	return s.upsert(ctx, s.db, e)
+}
+
+// delivered returns the sinks the entry was delivered to.
+func (s *SQLiteStore) delivered(ctx context.Context, key string) ([]string, error) {
+	var b sql.NullString
+	err := s.db.QueryRowContext(ctx, "SELECT delivered FROM entries WHERE key = ?", key).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) || !b.Valid {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sinks []string
+	if err := json.Unmarshal([]byte(b.String), &sinks); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal delivery state of %s", key)
+	}
+
+	return sinks, nil
+}
+
+// Get gets an event from the store.
+func (s *SQLiteStore) Get(ctx context.Context, key string, value IndexMarshaller) error {
+	ctx = trace.StartCall(ctx, "sqlite.Get")
+	defer trace.EndCall(ctx)
+
+	if s.db == nil {
+		return trace.SetCallStatus(ctx, fmt.Errorf("store is not initialized"))
+	}
+
+	data, err := s.data(ctx, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return trace.SetCallStatus(ctx, err)
+	}
+
+	return trace.SetCallStatus(ctx, value.UnmarshalRecord(data))
+}
+
+// data reads the data of the entry.
+func (s *SQLiteStore) data(ctx context.Context, key string) (map[string]interface{}, error) {
+	var b string
+	if err := s.db.QueryRowContext(ctx, "SELECT data FROM entries WHERE key = ?", key).Scan(&b); err != nil {
+		return nil, err
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(b), &data); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal entry %s", key)
+	}
+
+	return data, nil
+}
+
+// GetAll returns all the events in the store. The latest versions of the events.
+func (s *SQLiteStore) GetAll(ctx context.Context) *Cursor {
+	return s.Query(ctx, Filter{})
+}
+
+// GetUnprocessed returns all the events in the store that have not been processed.
+func (s *SQLiteStore) GetUnprocessed(ctx context.Context) *Cursor {
+	processed := false
+	return s.Query(ctx, Filter{Processed: &processed})
+}
+
+// Query returns the latest versions of the events matching the filter, in the order they were appended.
+// The processed flag and the time range are matched by the database, the delivery state and the event fields
+// once the entries are read.
+// The cursor reads the matched events from the database as it's iterated.
+func (s *SQLiteStore) Query(ctx context.Context, f Filter) *Cursor {
+	ctx = trace.StartCall(ctx, "sqlite.Query")
+	defer trace.EndCall(ctx)
+
+	if s.db == nil {
+		//nolint:errcheck // Why: We only track the error, the cursor is empty.
+		trace.SetCallStatus(ctx, fmt.Errorf("store is not initialized"))
+		return NewCursor(nil)
+	}
+
+	var conds []string
+	var args []interface{}
+	if f.Processed != nil {
+		conds = append(conds, "processed = ?")
+		args = append(args, *f.Processed)
+	}
+	if !f.Since.IsZero() {
+		conds = append(conds, "timestamp >= ?")
+		args = append(args, f.Since.UnixMilli())
+	}
+	if !f.Until.IsZero() {
+		conds = append(conds, "timestamp < ?")
+		args = append(args, f.Until.UnixMilli())
+	}
+
+	query := "SELECT key, data, delivered FROM entries"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY seq"
+
+	keys, err := s.queryKeys(ctx, &f, query, args)
+	if err != nil {
+		//nolint:errcheck // Why: We only track the error, the cursor is empty.
+		trace.SetCallStatus(ctx, err)
+		return NewCursor(nil)
+	}
+
+	return newLazyCursor(len(keys), func(i int) (map[string]interface{}, error) {
+		return s.data(context.Background(), keys[i])
+	}, nil)
+}
+
+// queryKeys returns the keys of the entries returned by query that match the filter fields.
+func (s *SQLiteStore) queryKeys(ctx context.Context, f *Filter, query string, args []interface{}) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query entries")
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key, b string
+		var d sql.NullString
+		if err := rows.Scan(&key, &b, &d); err != nil {
+			return nil, err
+		}
+
+		if f.Undelivered != "" && d.Valid {
+			var sinks []string
+			if err := json.Unmarshal([]byte(d.String), &sinks); err == nil && delivered(sinks, f.Undelivered) {
+				continue
+			}
+		}
+
+		if f.needsData() {
+			var data map[string]interface{}
+			if err := json.Unmarshal([]byte(b), &data); err != nil || !f.matchData(data) {
+				continue
+			}
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// MarkProcessed marks the events as processed.
+func (s *SQLiteStore) MarkProcessed(ctx context.Context, recs []IndexMarshaller) error {
+	ctx = trace.StartCall(ctx, "sqlite.MarkProcessed")
+	defer trace.EndCall(ctx)
+
+	for _, rec := range recs {
+		if err := s.append(ctx, rec, true, nil); err != nil {
+			return trace.SetCallStatus(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+// MarkDelivered records that the events were delivered to the named sink.
+func (s *SQLiteStore) MarkDelivered(ctx context.Context, sink string, recs []IndexMarshaller) error {
+	ctx = trace.StartCall(ctx, "sqlite.MarkDelivered")
+	defer trace.EndCall(ctx)
+
+	for _, rec := range recs {
+		if err := s.append(ctx, rec, false, []string{sink}); err != nil {
+			return trace.SetCallStatus(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+// Lock acquires an exclusive lock on the store. SQLite serializes the writes itself, the lock makes
+// sequences of reads and writes (e.g. flushing the events) exclusive across processes.
+func (s *SQLiteStore) Lock(ctx context.Context) (func(), error) {
+	ctx = trace.StartCall(ctx, "sqlite.Lock")
+	defer trace.EndCall(ctx)
+
+	if err := s.locker.Lock(); err != nil {
+		return nil, trace.SetCallStatus(ctx, errors.Wrap(err, "failed to lock database"))
+	}
+
+	return func() {
+		//nolint:errcheck // Why: The lock is released with the lock file being closed.
+		s.locker.Unlock()
+	}, nil
+}
+
+// GC removes the entries over the retention policy. These are the entries that were not updated for longer than
+// the retention max age, and the oldest entries while the data of the entries is over the retention max bytes.
+// The last entry is never removed for size. It returns the removed entries named by their keys, with dryRun it only
+// returns the entries that would be removed. The database file is vacuumed, so that it shrinks.
+func (s *SQLiteStore) GC(ctx context.Context, dryRun bool) ([]Segment, error) {
+	ctx = trace.StartCall(ctx, "sqlite.GC")
+	defer trace.EndCall(ctx)
+
+	if s.db == nil {
+		return nil, trace.SetCallStatus(ctx, fmt.Errorf("store is not initialized"))
+	}
+
+	if err := s.locker.Lock(); err != nil {
+		return nil, trace.SetCallStatus(ctx, errors.Wrap(err, "failed to lock database"))
+	}
+	//nolint:errcheck // Why: The lock is released with the lock file being closed.
+	defer s.locker.Unlock()
+
+	entries, err := s.segments(ctx)
+	if err != nil {
+		return nil, trace.SetCallStatus(ctx, err)
+	}
+
+	expired := expiredSegments(entries, time.Now(), s.retentionMaxAge, s.retentionMaxBytes)
+	trace.AddInfo(ctx, log.F{"sqlite.expired_entries": len(expired), "sqlite.dry_run": dryRun})
+	if dryRun || len(expired) == 0 {
+		return expired, trace.SetCallStatus(ctx, nil)
+	}
+
+	if err := s.remove(ctx, expired); err != nil {
+		return nil, trace.SetCallStatus(ctx, err)
+	}
+
+	// VACUUM can't run in a transaction, the entries are removed already.
+	if _, err := s.db.ExecContext(ctx, "VACUUM"); err != nil {
+		trace.AddInfo(ctx, log.F{"sqlite.vacuum_error": err.Error()})
+	}
+
+	return expired, trace.SetCallStatus(ctx, nil)
+}
+
+// segments lists the entries as segments of the size of their data, in the order they were appended.
+func (s *SQLiteStore) segments(ctx context.Context) ([]Segment, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT key, LENGTH(CAST(data AS BLOB)), updated_at FROM entries ORDER BY seq")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list entries")
+	}
+	defer rows.Close()
+
+	var segments []Segment
+	for rows.Next() {
+		var seg Segment
+		var updatedAt int64
+		if err := rows.Scan(&seg.Name, &seg.Size, &updatedAt); err != nil {
+			return nil, err
+		}
+		seg.ModTime = time.UnixMilli(updatedAt)
+		segments = append(segments, seg)
+	}
+
+	return segments, rows.Err()
+}
+
+// remove deletes the entries of the segments.
+func (s *SQLiteStore) remove(ctx context.Context, segments []Segment) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck // Why: Rollback fails after commit.
+	defer tx.Rollback()
+
+	for _, seg := range segments {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM entries WHERE key = ?", seg.Name); err != nil {
+			return errors.Wrapf(err, "failed to remove %s", seg.Name)
+		}
+	}
+
+	return tx.Commit()
+}
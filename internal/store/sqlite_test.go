@@ -0,0 +1,212 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewSQLite(&Options{LogDir: t.TempDir()})
+	assert.NoError(t, s.Init(ctx))
+	defer s.Close()
+
+	s.AddDefaultField("branch", "main")
+	assert.NoError(t, s.Append(ctx, &payload{ID: "1", Content: "one"}))
+	assert.NoError(t, s.Append(ctx, &payload{ID: "2", Content: "two"}))
+	assert.NoError(t, s.Append(ctx, &payload{ID: "1", Content: "one again"}))
+
+	var p payload
+	assert.NoError(t, s.Get(ctx, "1", &p))
+	assert.Equal(t, "one again", p.Content)
+
+	// Missing keys leave the value untouched, like FSStore.
+	assert.NoError(t, s.Get(ctx, "missing", &p))
+
+	e := event{}
+	c := s.GetAll(ctx)
+	assert.Equal(t, 2, c.Len())
+	assert.True(t, c.Next())
+	assert.NoError(t, c.Value(e))
+	assert.Equal(t, "2", e["id"])
+	assert.Equal(t, "main", e["branch"])
+
+	assert.NoError(t, s.MarkProcessed(ctx, []IndexMarshaller{&payload{ID: "2", Content: "two"}}))
+	c = s.GetUnprocessed(ctx)
+	assert.Equal(t, 1, c.Len())
+	assert.True(t, c.Next())
+	assert.NoError(t, c.Value(&p))
+	assert.Equal(t, "1", p.ID)
+
+	unlock, err := s.Lock(ctx)
+	assert.NoError(t, err)
+	unlock()
+}
+
+func TestSQLiteQuery(t *testing.T) {
+	ctx := context.Background()
+	s := NewSQLite(&Options{LogDir: t.TempDir()})
+	assert.NoError(t, s.Init(ctx))
+	defer s.Close()
+
+	base := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	events := []event{
+		{"hook": "before:deploy", "execution_id": "1", "status": "info", "timestamp": base.UnixMilli()},
+		{"hook": "after:deploy", "execution_id": "1", "status": "info", "timestamp": base.Add(time.Minute).UnixMilli()},
+		{"hook": "error:build", "execution_id": "2", "status": "error", "timestamp": base.Add(time.Hour).UnixMilli(),
+			"command": map[string]interface{}{"name": "build"}},
+	}
+	for _, e := range events {
+		assert.NoError(t, s.Append(ctx, e))
+	}
+
+	processed := false
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"all", Filter{}, []string{"1_before:deploy", "1_after:deploy", "2_error:build"}},
+		{"time range", Filter{Since: base.Add(time.Minute), Until: base.Add(time.Hour)}, []string{"1_after:deploy"}},
+		{"hook prefix", Filter{HookPrefix: "before:"}, []string{"1_before:deploy"}},
+		{"status", Filter{Status: "error"}, []string{"2_error:build"}},
+		{"field", Filter{Fields: map[string]interface{}{"command.name": "build"}}, []string{"2_error:build"}},
+		{"processed", Filter{Processed: &processed, ExecutionID: "1"}, []string{"1_before:deploy", "1_after:deploy"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, queryKeys(t, s.Query(ctx, tt.filter)))
+		})
+	}
+}
+
+func TestSQLiteMigratesJSONL(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	fs := New(&Options{LogDir: dir})
+	assert.NoError(t, fs.Init(ctx))
+	assert.NoError(t, fs.Append(ctx, &payload{ID: "1", Content: "one"}))
+	assert.NoError(t, fs.Append(ctx, &payload{ID: "2", Content: "two"}))
+	assert.NoError(t, fs.MarkProcessed(ctx, []IndexMarshaller{&payload{ID: "1", Content: "one"}}))
+
+	s := NewSQLite(&Options{LogDir: dir})
+	assert.NoError(t, s.Init(ctx))
+	assert.Equal(t, 2, s.GetAll(ctx).Len())
+
+	var p payload
+	c := s.GetUnprocessed(ctx)
+	assert.True(t, c.Next())
+	assert.NoError(t, c.Value(&p))
+	assert.Equal(t, "2", p.ID)
+	assert.NoError(t, s.Close())
+
+	// The migration is done once, later log entries are not imported.
+	assert.NoError(t, fs.Append(ctx, &payload{ID: "3"}))
+	s = NewSQLite(&Options{LogDir: dir})
+	assert.NoError(t, s.Init(ctx))
+	defer s.Close()
+	assert.Equal(t, 2, s.GetAll(ctx).Len())
+}
+
+// failingLocker fails to lock the given number of times, and then locks with the locker.
+type failingLocker struct {
+	Locker
+	failures int
+}
+
+func (l *failingLocker) Lock() error {
+	if l.failures > 0 {
+		l.failures--
+		return errors.New("lock failed")
+	}
+	return l.Locker.Lock()
+}
+
+func TestSQLiteInitRetriesFailedMigrations(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	fs := New(&Options{LogDir: dir})
+	assert.NoError(t, fs.Init(ctx))
+	assert.NoError(t, fs.Append(ctx, &payload{ID: "1", Content: "one"}))
+
+	locker := &failingLocker{Locker: NewFileLocker(filepath.Join(dir, "devtel.db.lock")), failures: 1}
+	s := NewSQLite(&Options{LogDir: dir, Locker: locker})
+	assert.Error(t, s.Init(ctx))
+
+	assert.NoError(t, s.Init(ctx))
+	defer s.Close()
+	assert.Equal(t, 1, s.GetAll(ctx).Len())
+}
+
+func TestSQLiteMigrationLeavesLogFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	damaged := `{"key":"1","data":{"id":"1"}}` + "\n" + `{"key":"2` + "\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "1.log"), []byte(damaged), 0o600))
+	old := time.Now().Add(-48 * time.Hour)
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "1.log"), old, old))
+
+	s := NewSQLite(&Options{LogDir: dir, RetentionMaxAge: 24 * time.Hour})
+	assert.NoError(t, s.Init(ctx))
+	defer s.Close()
+	assert.Equal(t, 1, s.GetAll(ctx).Len())
+
+	b, err := os.ReadFile(filepath.Join(dir, "1.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, damaged, string(b))
+	assert.NoFileExists(t, filepath.Join(dir, ".1.log.corrupt"))
+}
+
+func TestNewStore(t *testing.T) {
+	s, err := NewStore(&Options{LogDir: t.TempDir(), Backend: BackendSQLite})
+	assert.NoError(t, err)
+	assert.IsType(t, &SQLiteStore{}, s)
+
+	s, err = NewStore(&Options{LogDir: t.TempDir()})
+	assert.NoError(t, err)
+	assert.IsType(t, &FSStore{}, s)
+
+	_, err = NewStore(&Options{Backend: "csv"})
+	assert.Error(t, err)
+}
+
+func TestSQLiteGC(t *testing.T) {
+	ctx := context.Background()
+	s := NewSQLite(&Options{LogDir: t.TempDir(), RetentionMaxAge: 24 * time.Hour, RetentionMaxBytes: 15})
+	assert.NoError(t, s.Init(ctx))
+	defer s.Close()
+
+	for _, id := range []string{"1", "2", "3"} {
+		assert.NoError(t, s.Append(ctx, &payload{ID: id}))
+	}
+	_, err := s.db.ExecContext(ctx, "UPDATE entries SET updated_at = ? WHERE key = ?",
+		time.Now().Add(-48*time.Hour).UnixMilli(), "1")
+	assert.NoError(t, err)
+
+	expired, err := s.GC(ctx, true)
+	assert.NoError(t, err)
+	assert.Len(t, expired, 2)
+	assert.Equal(t, 3, s.GetAll(ctx).Len())
+
+	expired, err = s.GC(ctx, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", expired[0].Name)
+	assert.Equal(t, "2", expired[1].Name)
+
+	c := s.GetAll(ctx)
+	assert.Equal(t, 1, c.Len())
+	var p payload
+	assert.True(t, c.Next())
+	assert.NoError(t, c.Value(&p))
+	assert.Equal(t, "3", p.ID)
+}
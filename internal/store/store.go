@@ -7,6 +7,17 @@
 // and appends it to the log file. The events are managed based on a key. Key is provided by the caller.
 // It also tracks whether the event has been processed or not. This is useful for determining if the event
 // needs to be sent to telemetry or not.
+// Multiple processes (devspace runs hooks in parallel) can share the same log dir. Reads are guarded by a shared
+// lock and writes by an exclusive lock on a lock file in the log dir. Files starting with a dot are not restored.
+// Corrupt lines (e.g. from a crashed hook) are skipped on restore, and the damaged files are repaired.
+// New entries are always appended to the lexically last log file (segment). New segments are started when
+// the current one is over the configured limits, and the old segments that hold only superseded or processed entries
+// are removed. Compaction rewrites the log into a single segment with the latest versions of the entries.
+// The log files over the retention policy (max age, max total size) are removed by GC, which is left to the commands
+// off the path of the hooks, as it locks the log dir exclusively.
+// Only a compact index of the latest versions of the entries is kept in memory, the entries themselves are read
+// from the log files as the cursors are iterated. When the log files are provided by the caller (LogFS or
+// OpenAppend are set), the entries are kept in memory.
 package store
 
 import (
@@ -18,18 +29,21 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
-	"sort"
+	"strings"
 	"time"
 
+	"github.com/getoutreach/gobox/pkg/log"
 	"github.com/getoutreach/gobox/pkg/trace"
 	"github.com/pkg/errors"
 )
 
 // entry is index entry for wrapping the event, tracking event key, and whether the event was processed.
+// Delivered holds the sinks the event was delivered to, while it's not processed by all of them.
 type entry struct {
 	Key       string                 `json:"key"`
 	Data      map[string]interface{} `json:"data"`
 	Processed bool                   `json:"processed,omitempty"`
+	Delivered []string               `json:"delivered,omitempty"`
 }
 
 // bag is an map alias that provides a MarshalRecord method. It's used to hold default fields.
@@ -52,19 +66,52 @@ type Store interface {
 	Get(context.Context, string, IndexMarshaller) error
 	GetAll(context.Context) *Cursor
 	GetUnprocessed(context.Context) *Cursor
+	Query(context.Context, Filter) *Cursor
 
 	MarkProcessed(context.Context, []IndexMarshaller) error
+	// MarkDelivered records that the events were delivered to the named sink. They stay unprocessed,
+	// until they're delivered to all the sinks.
+	MarkDelivered(ctx context.Context, sink string, recs []IndexMarshaller) error
+
+	// Lock acquires an exclusive lock on the store, so that a sequence of reads and writes is not interleaved
+	// with other processes. The returned function releases the lock.
+	Lock(context.Context) (func(), error)
 }
 
 // FSStore is the concrete implementation of Store.
 type FSStore struct {
 	logDir     string
+	logName    string
 	logPath    string
 	logFS      fs.FS
 	openAppend func(path string) (io.WriteCloser, error)
+	locker     Locker
+	// managed is true when the store accesses the log dir directly, and can repair the files in it.
+	managed bool
+
+	index map[string]record
+	seq   int
+	// offsets tracks how much of each log file has been restored. It's nil until the store is initialized.
+	offsets map[string]int64
+	// files holds the restored log files, to tell when another process replaced one, e.g. by repairing it.
+	files map[string]fs.FileInfo
+	// lastName is the lexically last log file in the log dir.
+	lastName string
+	// segmentEntries holds the number of entries in each log file.
+	segmentEntries map[string]int
+	// damaged holds the log files in which corrupt lines were found.
+	damaged map[string]bool
+	corrupt int
+
+	compactionThreshold int
+	processedRetention  time.Duration
+	maxSegmentBytes     int64
+	maxSegmentAge       time.Duration
+	maxSegmentEntries   int
+	retentionMaxAge     time.Duration
+	retentionMaxBytes   int64
+	sync                SyncPolicy
 
-	entries       []entry
-	index         map[string]int
 	defaultFields bag
 }
 
@@ -73,12 +120,96 @@ type Options struct {
 	LogDir     string
 	LogFS      fs.FS
 	OpenAppend func(path string) (io.WriteCloser, error)
+
+	// Locker coordinates access across processes. Defaults to a lock file in LogDir when the store manages
+	// the files itself (LogFS and OpenAppend are not set), otherwise no locking is done.
+	Locker Locker
+
+	// CompactionThreshold is the number of superseded entries that triggers compaction on Init.
+	// Zero disables it.
+	CompactionThreshold int
+	// ProcessedRetention is how long processed entries are kept on compaction. Defaults to a week, it needs to
+	// be longer than devspace commands run, so that before hooks can be matched.
+	ProcessedRetention time.Duration
+
+	// MaxSegmentBytes, MaxSegmentAge and MaxSegmentEntries limit the current log file. A new one is started
+	// once any of them is reached. Zero disables the limit.
+	MaxSegmentBytes   int64
+	MaxSegmentAge     time.Duration
+	MaxSegmentEntries int
+
+	// RetentionMaxAge and RetentionMaxBytes is the retention policy GC enforces. The files (or the SQLite entries)
+	// not modified for RetentionMaxAge are removed, and so are the oldest ones while the store is over
+	// RetentionMaxBytes. They default to 30 days and 64 MiB, negative value disables the limit.
+	RetentionMaxAge   time.Duration
+	RetentionMaxBytes int64
+
+	// Sync is the fsync policy of the appends. Defaults to SyncNever.
+	Sync SyncPolicy
+
+	// Backend selects the Store implementation created by NewStore. Defaults to BackendJSONL.
+	Backend Backend
+	// SQLitePath is the database file of the SQLite store. Defaults to .devtel.db in LogDir.
+	SQLitePath string
+}
+
+// Backend is the kind of storage backing a Store.
+type Backend string
+
+const (
+	// BackendJSONL stores the events in JSONL log files, see FSStore.
+	BackendJSONL Backend = "jsonl"
+	// BackendSQLite stores the events in an SQLite database, see SQLiteStore.
+	BackendSQLite Backend = "sqlite"
+	// BackendMemory keeps the events in memory, see MemoryStore.
+	BackendMemory Backend = "memory"
+)
+
+// SyncPolicy decides when the appended entries are synced to disk.
+type SyncPolicy int
+
+const (
+	// SyncNever leaves syncing to the operating system. The last entries can be lost on power loss,
+	// the envelope of the log lines detects the torn ones.
+	SyncNever SyncPolicy = iota
+	// SyncAlways syncs the log file, and the log dir when the file is created, before the append returns.
+	SyncAlways
+)
+
+// NewStore creates the Store selected by opts.Backend.
+func NewStore(opts *Options) (Store, error) {
+	switch opts.Backend {
+	case "", BackendJSONL:
+		return New(opts), nil
+	case BackendSQLite:
+		return NewSQLite(opts), nil
+	case BackendMemory:
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", opts.Backend)
+	}
+}
+
+// DefaultLogDir returns the log dir used when Options.LogDir is not set.
+func DefaultLogDir() string {
+	return filepath.Join(os.TempDir(), "devtel")
 }
 
 // New creates a new FSStore instance.
 func New(opts *Options) *FSStore {
 	if opts.LogDir == "" {
-		opts.LogDir = filepath.Join(os.TempDir(), "devtel")
+		opts.LogDir = DefaultLogDir()
+	}
+
+	// The store manages the log dir itself, unless the caller provides a way to access the files.
+	managed := opts.LogFS == nil && opts.OpenAppend == nil
+
+	if opts.Locker == nil {
+		if managed {
+			opts.Locker = NewFileLocker(filepath.Join(opts.LogDir, ".lock"))
+		} else {
+			opts.Locker = nopLocker{}
+		}
 	}
 
 	if opts.LogFS == nil {
@@ -91,11 +222,27 @@ func New(opts *Options) *FSStore {
 		}
 	}
 
+	if opts.ProcessedRetention == 0 {
+		opts.ProcessedRetention = 7 * 24 * time.Hour
+	}
+
+	setRetentionDefaults(opts)
+
 	return &FSStore{
-		logDir:        opts.LogDir,
-		logFS:         opts.LogFS,
-		openAppend:    opts.OpenAppend,
-		defaultFields: bag{},
+		logDir:              opts.LogDir,
+		logFS:               opts.LogFS,
+		openAppend:          opts.OpenAppend,
+		locker:              opts.Locker,
+		managed:             managed,
+		compactionThreshold: opts.CompactionThreshold,
+		processedRetention:  opts.ProcessedRetention,
+		maxSegmentBytes:     opts.MaxSegmentBytes,
+		maxSegmentAge:       opts.MaxSegmentAge,
+		maxSegmentEntries:   opts.MaxSegmentEntries,
+		retentionMaxAge:     opts.RetentionMaxAge,
+		retentionMaxBytes:   opts.RetentionMaxBytes,
+		sync:                opts.Sync,
+		defaultFields:       bag{},
 	}
 }
 
@@ -111,13 +258,76 @@ func (s *FSStore) Init(ctx context.Context) error {
 		}
 	}
 
+	if err := s.load(); err != nil {
+		return trace.SetCallStatus(ctx, err)
+	}
+
+	if s.corrupt > 0 {
+		trace.AddInfo(ctx, log.F{"store.corrupt_lines": s.corrupt, "store.damaged_files": len(s.damaged)})
+	}
+
+	if err := s.repair(); err != nil {
+		return trace.SetCallStatus(ctx, err)
+	}
+
+	if s.logName == "" {
+		s.logName = s.lastName
+	}
+
+	if s.logName == "" {
+		s.logName = s.newLogName()
+	}
+	s.logPath = filepath.Join(s.logDir, s.logName)
+
+	if s.managed && s.compactionThreshold > 0 && s.seq-len(s.index) >= s.compactionThreshold {
+		if err := s.Compact(ctx); err != nil {
+			// The store is still usable, it's compacted next time.
+			trace.AddInfo(ctx, log.F{"store.compaction_error": err.Error()})
+		}
+	}
+
+	return trace.SetCallStatus(ctx, nil)
+}
+
+// load restores the log files under a shared lock.
+func (s *FSStore) load() error {
+	if err := s.locker.RLock(); err != nil {
+		return errors.Wrap(err, "failed to lock log dir")
+	}
+	//nolint:errcheck // Why: The lock is released with the lock file being closed.
+	defer s.locker.Unlock()
+
+	if s.offsets == nil {
+		s.offsets = make(map[string]int64)
+	}
+
+	return s.refresh()
+}
+
+// refresh restores the entries appended to the log files since the last refresh.
+// It's a no-op until the store is initialized, so that stores that are not initialized only see their own appends.
+func (s *FSStore) refresh() error {
+	if s.offsets == nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
 	err := fs.WalkDir(s.logFS, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if d.IsDir() {
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
 			return nil
 		}
+		seen[path] = true
+
+		info, err := d.Info()
+		if err != nil {
+			return errors.Wrapf(err, "failed to stat %s", path)
+		}
+		if s.replaced(path, info) {
+			return errReplaced
+		}
 
 		f, err := s.logFS.Open(path)
 		if err != nil {
@@ -125,24 +335,108 @@ func (s *FSStore) Init(ctx context.Context) error {
 		}
 		defer f.Close()
 
-		if err := s.restore(f); err != nil {
+		if err := skip(f, s.offsets[path]); err != nil {
+			return errors.Wrapf(err, "failed to read %s", path)
+		}
+
+		n, corrupt, err := s.restore(f, path)
+		s.offsets[path] += n
+		if err != nil {
 			return errors.Wrapf(err, "failed to restore %s", path)
 		}
+		if s.managed {
+			if s.files == nil {
+				s.files = make(map[string]fs.FileInfo)
+			}
+			s.files[path] = info
+		}
 
-		s.logPath = filepath.Join(s.logDir, path)
+		if corrupt > 0 {
+			if s.damaged == nil {
+				s.damaged = make(map[string]bool)
+			}
+			s.damaged[path] = true
+			s.corrupt += corrupt
+		}
 
 		return nil
 	})
-
+	if errors.Is(err, errReplaced) {
+		// The records point to the offsets in the replaced file, and the entries in it can be superseded
+		// by the other files, so all of them are restored again.
+		return s.reload()
+	}
 	if err != nil {
-		return trace.SetCallStatus(ctx, err)
+		return err
+	}
+
+	// Forget the files removed by compaction in other processes.
+	s.lastName = ""
+	removed := make(map[string]bool)
+	for path := range s.offsets {
+		if !seen[path] {
+			delete(s.offsets, path)
+			delete(s.files, path)
+			removed[path] = true
+			continue
+		}
+		if path > s.lastName {
+			s.lastName = path
+		}
+	}
+	if len(removed) > 0 {
+		s.dropFiles(removed)
+	}
+
+	return nil
+}
+
+// skip skips the restored part of the log file. The files of the log dir are seeked, the others are read
+// up to the offset. The size of the file was checked against the offset already.
+func skip(f fs.File, offset int64) error {
+	if seeker, ok := f.(io.Seeker); ok {
+		_, err := seeker.Seek(offset, io.SeekStart)
+		return err
+	}
+
+	_, err := io.CopyN(io.Discard, f, offset)
+	return err
+}
+
+// errReplaced is returned by the walk of refresh when a restored log file was replaced.
+var errReplaced = errors.New("log file replaced")
+
+// replaced returns true if the restored log file was replaced by another one, e.g. by the repair in another
+// process, or truncated. Appending to the restored file only grows it.
+func (s *FSStore) replaced(path string, info fs.FileInfo) bool {
+	if info.Size() < s.offsets[path] {
+		return true
+	}
+
+	prev, ok := s.files[path]
+	return ok && !os.SameFile(prev, info)
+}
+
+// Lock acquires an exclusive lock on the log dir and restores the entries other processes appended since Init.
+func (s *FSStore) Lock(ctx context.Context) (func(), error) {
+	ctx = trace.StartCall(ctx, "store.Lock")
+	defer trace.EndCall(ctx)
+
+	if err := s.locker.Lock(); err != nil {
+		return nil, trace.SetCallStatus(ctx, errors.Wrap(err, "failed to lock log dir"))
 	}
 
-	if s.logPath == "" {
-		s.logPath = filepath.Join(s.logDir, fmt.Sprintf("%d.log", time.Now().Unix()))
+	unlock := func() {
+		//nolint:errcheck // Why: The lock is released with the lock file being closed.
+		s.locker.Unlock()
 	}
 
-	return trace.SetCallStatus(ctx, err)
+	if err := s.refresh(); err != nil {
+		unlock()
+		return nil, trace.SetCallStatus(ctx, err)
+	}
+
+	return unlock, nil
 }
 
 // AddDefaultField adds a default field to the store. These fields are added to all events.
@@ -155,46 +449,111 @@ func (s *FSStore) Append(ctx context.Context, value IndexMarshaller) error {
 	ctx = trace.StartCall(ctx, "store.Append")
 	defer trace.EndCall(ctx)
 
-	return trace.SetCallStatus(ctx, s.append(value, false))
+	return trace.SetCallStatus(ctx, s.append(value, false, nil))
 }
 
 // append adds an event to the store.
 // It adds default fields, and marshals the data. Then it appends the to the log file and in-memory index.
-func (s *FSStore) append(value IndexMarshaller, processed bool) error {
+// The log dir is locked exclusively for the write, and entries appended by other processes are restored first.
+// The sinks the event was delivered to are added to those of the previous version of the event.
+func (s *FSStore) append(value IndexMarshaller, processed bool, delivered []string) error {
 	val := make(map[string]interface{})
 
 	adder := addField(val)
 	s.defaultFields.MarshalRecord(adder)
 	value.MarshalRecord(adder)
 
-	e := entry{value.Key(), val, processed}
+	if err := s.locker.Lock(); err != nil {
+		return errors.Wrap(err, "failed to lock log dir")
+	}
+	//nolint:errcheck // Why: The lock is released with the lock file being closed.
+	defer s.locker.Unlock()
+
+	if err := s.refresh(); err != nil {
+		return err
+	}
+
+	e := entry{Key: value.Key(), Data: val, Processed: processed}
+	if len(delivered) > 0 {
+		e.Delivered = mergeSinks(s.index[e.Key].delivered, delivered)
+	}
 	b, err := json.Marshal(e)
 	if err != nil {
 		return err
 	}
+
+	// Other processes could have started a new log file, appending to an older one would make
+	// the entries restored before the ones they supersede.
+	if s.managed && s.lastName > s.logName {
+		s.logName = s.lastName
+		s.logPath = filepath.Join(s.logDir, s.logName)
+	}
+
+	if err := s.rotate(); err != nil {
+		return err
+	}
+
+	if err := s.truncateIncomplete(); err != nil {
+		return err
+	}
+
 	f, err := s.openAppend(s.logPath)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	_, err = fmt.Fprintln(f, string(b))
+	// The line is written at once, so that it's not interleaved with the other writers.
+	created := s.offsets[s.logName] == 0
+	n, err := f.Write(encodeLine(b))
 	if err != nil {
 		return err
 	}
 
-	s.appendEntry(e)
+	if err := s.syncAppend(f, created); err != nil {
+		return errors.Wrap(err, "failed to sync log file")
+	}
+
+	r := record{key: e.Key, processed: processed, delivered: e.Delivered}
+	r.timestamp, r.hasTimestamp = millis(val["timestamp"])
+	if s.managed && s.offsets != nil {
+		r.file = s.logName
+		r.offset = s.offsets[s.logName]
+		r.length = n
+		s.offsets[s.logName] += int64(n)
+	} else {
+		// The entry can't be read back from the log files.
+		r.data = val
+	}
+	s.indexRecord(r)
 
 	return nil
 }
 
-// appendEntry adds an entry to the in-memory index.
-func (s *FSStore) appendEntry(e entry) {
-	if s.index == nil {
-		s.index = make(map[string]int)
+// syncAppend syncs the appended log file according to the sync policy.
+// The log dir is synced too when the file could have been created, so that the file itself is not lost.
+func (s *FSStore) syncAppend(w io.Writer, created bool) error {
+	if s.sync != SyncAlways {
+		return nil
 	}
-	s.index[e.Key] = len(s.entries)
-	s.entries = append(s.entries, e)
+
+	if f, ok := w.(interface{ Sync() error }); ok {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+
+	if !s.managed || !created {
+		return nil
+	}
+
+	dir, err := os.Open(s.logDir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
 }
 
 // Get gets an event from the store.
@@ -202,8 +561,16 @@ func (s *FSStore) Get(ctx context.Context, key string, value IndexMarshaller) er
 	ctx = trace.StartCall(ctx, "store.Get")
 	defer trace.EndCall(ctx)
 
-	if i, ok := s.index[key]; ok {
-		return trace.SetCallStatus(ctx, value.UnmarshalRecord(s.entries[i].Data))
+	if r, ok := s.index[key]; ok {
+		rr := recordReader{fsys: s.logFS}
+		//nolint:errcheck // Why: The file is only read.
+		defer rr.Close()
+
+		e, err := rr.entry(&r)
+		if err != nil {
+			return trace.SetCallStatus(ctx, err)
+		}
+		return trace.SetCallStatus(ctx, value.UnmarshalRecord(e.Data))
 	}
 
 	return nil
@@ -214,18 +581,7 @@ func (s *FSStore) GetAll(ctx context.Context) *Cursor {
 	ctx = trace.StartCall(ctx, "store.GetAll")
 	defer trace.EndCall(ctx)
 
-	indexes := make([]int, 0, len(s.entries))
-	for _, index := range s.index {
-		indexes = append(indexes, index)
-	}
-	sort.Ints(indexes)
-
-	var values []map[string]interface{}
-	for _, index := range indexes {
-		values = append(values, s.entries[index].Data)
-	}
-
-	return NewCursor(values)
+	return s.cursor(s.latest(nil))
 }
 
 // GetUnprocessed returns all the events in the store that have not been processed.
@@ -233,30 +589,32 @@ func (s *FSStore) GetUnprocessed(ctx context.Context) *Cursor {
 	ctx = trace.StartCall(ctx, "store.GetUnprocessed")
 	defer trace.EndCall(ctx)
 
-	indexes := make([]int, 0, len(s.entries))
-	for _, index := range s.index {
-		indexes = append(indexes, index)
-	}
-	sort.Ints(indexes)
+	return s.cursor(s.latest(func(r *record) bool {
+		return !r.processed
+	}))
+}
 
-	var values []map[string]interface{}
-	for _, index := range indexes {
-		val := s.entries[index]
-		if !val.Processed {
-			values = append(values, val.Data)
+// MarkProcessed marks the events as processed.
+func (s *FSStore) MarkProcessed(ctx context.Context, recs []IndexMarshaller) error {
+	ctx = trace.StartCall(ctx, "store.MarkProcessed")
+	defer trace.EndCall(ctx)
+
+	for _, rec := range recs {
+		if err := s.append(rec, true, nil); err != nil {
+			return trace.SetCallStatus(ctx, err)
 		}
 	}
 
-	return NewCursor(values)
+	return nil
 }
 
-// MarkProcessed marks the events as processed.
-func (s *FSStore) MarkProcessed(ctx context.Context, recs []IndexMarshaller) error {
-	ctx = trace.StartCall(ctx, "store.MarkProcessed")
+// MarkDelivered records that the events were delivered to the named sink.
+func (s *FSStore) MarkDelivered(ctx context.Context, sink string, recs []IndexMarshaller) error {
+	ctx = trace.StartCall(ctx, "store.MarkDelivered")
 	defer trace.EndCall(ctx)
 
 	for _, rec := range recs {
-		if err := s.append(rec, true); err != nil {
+		if err := s.append(rec, false, []string{sink}); err != nil {
 			return trace.SetCallStatus(ctx, err)
 		}
 	}
@@ -265,23 +623,60 @@ func (s *FSStore) MarkProcessed(ctx context.Context, recs []IndexMarshaller) err
 }
 
 // restore reads the log file and adds the entries to the in-memory index.
-func (s *FSStore) restore(r io.Reader) error {
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		var e entry
+// It returns the number of bytes restored and the number of corrupt lines skipped.
+// A trailing line without a newline is not restored, it's a write that hasn't finished.
+func (s *FSStore) restore(r io.Reader, file string) (n int64, corrupt int, err error) {
+	br := bufio.NewReader(r)
+	offset := s.offsets[file]
+	for {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return n, corrupt, nil
+		}
+		if err != nil {
+			return n, corrupt, err
+		}
+		n += int64(len(line))
+
+		b, err := decodeLine(line)
+		if err != nil {
+			corrupt++
+			continue
+		}
 
-		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
-			return errors.Wrap(err, "failed to unmarshal entry")
+		var h header
+		if err := json.Unmarshal(b, &h); err != nil {
+			corrupt++
+			continue
 		}
-		if e.Key == "" {
+
+		if h.Key == "" {
 			continue
 		}
-		if e.Data == nil {
+		if h.Data == nil {
 			continue
 		}
 
-		s.appendEntry(e)
-	}
+		rec := record{
+			key:       h.Key,
+			file:      file,
+			offset:    offset + n - int64(len(line)),
+			length:    len(line),
+			processed: h.Processed,
+			delivered: h.Delivered,
+		}
+		rec.timestamp, rec.hasTimestamp = millis(h.Data.Timestamp)
+
+		// The files provided by the caller can change under the offsets, their entries are kept in memory.
+		if !s.managed {
+			var e entry
+			if err := json.Unmarshal(b, &e); err != nil {
+				corrupt++
+				continue
+			}
+			rec.data = e.Data
+		}
 
-	return nil
+		s.indexRecord(rec)
+	}
 }
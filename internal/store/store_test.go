@@ -65,8 +65,8 @@ func TestStoreAppend(t *testing.T) {
 	assert.Nil(t, s.Append(context.Background(), &payload{ID: "id2"}))
 
 	expected := "" +
-		`{"key":"id1","data":{"id":"id1"}}` + "\n" +
-		`{"key":"id2","data":{"id":"id2"}}` + "\n"
+		LogLine(`{"key":"id1","data":{"id":"id1"}}`) +
+		LogLine(`{"key":"id2","data":{"id":"id2"}}`)
 
 	assert.Equal(t, expected, buff.String())
 }
@@ -154,7 +154,7 @@ func TestAddDefaultField(t *testing.T) {
 	s.AddDefaultField("dev.email", "yoda@outreach.io")
 
 	assert.NoError(t, s.Append(context.Background(), &payload{ID: "id1"}))
-	expected := `{"key":"id1","data":{"dev":{"email":"yoda@outreach.io"},"id":"id1"}}` + "\n"
+	expected := LogLine(`{"key":"id1","data":{"dev":{"email":"yoda@outreach.io"},"id":"id1"}}`)
 
 	assert.Equal(t, expected, buff.String())
 }
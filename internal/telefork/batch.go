@@ -0,0 +1,121 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the sending of event batches in chunks, in separate requests.
+
+package telefork
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/getoutreach/gobox/pkg/log"
+	"github.com/getoutreach/gobox/pkg/trace"
+	"github.com/pkg/errors"
+
+	"github.com/getoutreach/devtel/internal/httpsend"
+)
+
+// ErrNotSent is the error of the chunks that were not sent, because an earlier chunk failed in a way
+// that is likely to fail them too, e.g. the server is unreachable.
+var ErrNotSent = errors.New("chunk not sent")
+
+// Chunk is the outcome of sending a part of a batch of events in a single request.
+type Chunk struct {
+	// Start and End are the indexes of the chunk events in the batch, End is exclusive.
+	Start int
+	End   int
+
+	// Err is the error of sending the chunk, nil when it was delivered.
+	Err error
+	// Rejected is true when Telefork refused the event of the chunk, it's not going to be accepted when sent again.
+	// The chunks of several events that Telefork refuses are bisected, so that only the refused events are rejected.
+	Rejected bool
+}
+
+// SendChunks sends the events to Telefork in chunks within the batch limits. The chunks are sent in order,
+// once one fails after the retries, the rest is not sent (ErrNotSent), unless the chunk itself was rejected.
+// Sending is bounded by the send timeout of the client. It returns all the chunks of the batch, the chunks map
+// the outcome back to the events, so that only the events of the delivered chunks are marked processed
+// (see Processor.ProcessBatch).
+func (c *Client) SendChunks(ctx context.Context, events []interface{}) []Chunk {
+	ctx = trace.StartCall(ctx, "telefork.Client.SendChunks")
+	defer trace.EndCall(ctx)
+
+	if c.sendTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.sendTimeout)
+		defer cancel()
+	}
+
+	parts := c.limits.Split(events)
+
+	var chunks []Chunk
+	var stopped error
+	for _, part := range parts {
+		if part.Err != nil {
+			// The event can't be sent at all, it doesn't stop the rest.
+			chunks = append(chunks, Chunk{Start: part.Start, End: part.End, Err: part.Err, Rejected: true})
+			continue
+		}
+		if stopped != nil {
+			chunks = append(chunks, Chunk{Start: part.Start, End: part.End, Err: ErrNotSent})
+			continue
+		}
+
+		sent := c.sendChunk(ctx, events, part)
+		for _, chunk := range sent {
+			if chunk.Err != nil && !chunk.Rejected && stopped == nil {
+				stopped = chunk.Err
+			}
+		}
+		chunks = append(chunks, sent...)
+	}
+
+	var failed int
+	for _, chunk := range chunks {
+		if chunk.Err != nil {
+			failed++
+		}
+	}
+	trace.AddInfo(ctx, log.F{"telefork.chunks": len(chunks), "telefork.failed_chunks": failed})
+	//nolint:errcheck // Why: We only track the error, it's returned in the chunks.
+	trace.SetCallStatus(ctx, stopped)
+
+	return chunks
+}
+
+// sendChunk sends the chunk, and returns its outcome. When Telefork refuses a chunk of several events, e.g. for one
+// malformed event or for its size, the chunk is bisected and its halves are sent, so that only the refused events
+// are rejected. Once a half fails otherwise, the rest is not sent (ErrNotSent).
+func (c *Client) sendChunk(ctx context.Context, events []interface{}, part httpsend.Chunk) []Chunk {
+	err := c.sendWithRetries(ctx, part.Body)
+	chunk := Chunk{Start: part.Start, End: part.End, Err: err}
+
+	var se *httpsend.StatusError
+	if err == nil || !errors.As(err, &se) || !se.Rejected() {
+		return []Chunk{chunk}
+	}
+	if part.End-part.Start == 1 {
+		chunk.Rejected = true
+		return []Chunk{chunk}
+	}
+
+	mid := part.Start + (part.End-part.Start)/2
+	var chunks []Chunk
+	for _, half := range [][2]int{{part.Start, mid}, {mid, part.End}} {
+		if n := len(chunks); n > 0 && chunks[n-1].Err != nil && !chunks[n-1].Rejected {
+			chunks = append(chunks, Chunk{Start: half[0], End: half[1], Err: ErrNotSent})
+			continue
+		}
+
+		// The events of the chunk were marshaled already, they're marshaled the same way again.
+		body, err := json.Marshal(events[half[0]:half[1]])
+		if err != nil {
+			chunks = append(chunks, Chunk{Start: half[0], End: half[1], Err: err, Rejected: true})
+			continue
+		}
+		chunks = append(chunks, c.sendChunk(ctx, events, httpsend.Chunk{Start: half[0], End: half[1], Body: body})...)
+	}
+
+	return chunks
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package telefork
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/getoutreach/devtel/internal/httpsend"
+	"github.com/getoutreach/devtel/internal/httpsend/httpsendtest"
+)
+
+func TestSendChunks(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		mu.Unlock()
+
+		switch {
+		case strings.Contains(string(b), "invalid"):
+			// The invalid event is rejected, the rest is still sent.
+			w.WriteHeader(http.StatusBadRequest)
+		case strings.Contains(string(b), "unavailable"):
+			// The server goes down, the rest is not sent.
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	client := &Client{http: server.Client(), baseURL: server.URL, retry: httpsendtest.RetryPolicy(), limits: httpsend.BatchLimits{MaxEvents: 2}}
+	events := httpsendtest.Events("before:build", "after:build", "invalid", "before:deploy", "after:deploy",
+		"unavailable", "before:test", "after:test", "before:run")
+	chunks := client.SendChunks(context.Background(), events)
+
+	var got [][2]int
+	for _, c := range chunks {
+		got = append(got, [2]int{c.Start, c.End})
+	}
+	// The rejected chunk is bisected, so that only the invalid event is rejected.
+	assert.Equal(t, [][2]int{{0, 2}, {2, 3}, {3, 4}, {4, 6}, {6, 8}, {8, 9}}, got)
+	assert.NoError(t, chunks[0].Err)
+	assert.True(t, chunks[1].Rejected)
+	assert.NoError(t, chunks[2].Err)
+	assert.Error(t, chunks[3].Err)
+	assert.False(t, chunks[3].Rejected)
+	assert.ErrorIs(t, chunks[4].Err, ErrNotSent)
+	assert.ErrorIs(t, chunks[5].Err, ErrNotSent)
+
+	// The chunk that failed with the server was retried.
+	assert.Len(t, bodies, 4+httpsendtest.RetryPolicy().MaxAttempts)
+	assert.Equal(t, `[{"hook":"before:build"},{"hook":"after:build"}]`, bodies[0])
+}
@@ -5,68 +5,117 @@
 package telefork
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"fmt"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
-	"github.com/getoutreach/gobox/pkg/trace"
+	"github.com/pkg/errors"
+
+	"github.com/getoutreach/devtel/internal/httpsend"
 )
 
 // Client is the Telefork Service client.
 type Client struct {
 	http    *http.Client
 	baseURL string
+	headers http.Header
+	retry   httpsend.RetryPolicy
+	limits  httpsend.BatchLimits
+	// sendTimeout bounds sending a batch of events, zero disables it.
+	sendTimeout time.Duration
+	// compress enables gzip compression of the request bodies.
+	compress bool
 }
 
-// NewClient returns a new Telefork client.
-func NewClient(appName, apiKey string) *Client {
-	return NewClientWithHTTPClient(appName, apiKey, http.DefaultClient)
-}
+// New returns a new Telefork client configured by the options.
+func New(appName, apiKey string, opts ...Option) *Client {
+	cfg := clientConfig{
+		endpoint: DefaultEndpoint,
+		http:     http.DefaultClient,
+		headers:  make(http.Header),
+		send:     DefaultSendTimeout,
+		retry:    httpsend.DefaultRetryPolicy(),
+		limits:   httpsend.DefaultBatchLimits(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 
-// NewClientWithHTTPClient returns a new Telefork client with the given HTTP client.
-func NewClientWithHTTPClient(appName, apiKey string, client *http.Client) *Client {
-	baseURL := "https://telefork.outreach.io/"
-	if os.Getenv("OUTREACH_TELEFORK_ENDPOINT") != "" {
-		baseURL = os.Getenv("OUTREACH_TELEFORK_ENDPOINT")
+	// The client is copied, so that the one passed in can be shared with others.
+	client := *cfg.http
+	if cfg.timeout > 0 {
+		client.Timeout = cfg.timeout
+	} else if client.Timeout == 0 {
+		// A stalled connection would hang the flush otherwise, http.DefaultClient has no timeout.
+		client.Timeout = DefaultTimeout
+	}
+	if cfg.send < 0 {
+		cfg.send = 0
 	}
+	transport := cfg.transport
+	if transport == nil {
+		transport = client.Transport
+	}
+	client.Transport = NewTransport(appName, apiKey, transport)
 
-	client.Transport = NewTransport(appName, apiKey, client.Transport)
 	return &Client{
-		http:    client,
-		baseURL: baseURL,
+		http:        &client,
+		baseURL:     cfg.endpoint,
+		headers:     cfg.headers,
+		retry:       cfg.retry,
+		limits:      cfg.limits,
+		sendTimeout: cfg.send,
+		compress:    cfg.compress,
 	}
 }
 
-// SendEvents sends the given events to Telefork.
-func (c *Client) SendEvents(ctx context.Context, events []interface{}) error {
-	ctx = trace.StartCall(ctx, "telefork.Client.SendEvents")
-	defer trace.EndCall(ctx)
+// NewClient returns a new Telefork client configured by the environment variables.
+func NewClient(appName, apiKey string) *Client {
+	return New(appName, apiKey, envOptions()...)
+}
 
-	b, err := json.Marshal(events)
-	if err != nil {
-		return trace.SetCallStatus(ctx, err)
-	}
+// NewClientWithHTTPClient returns a new Telefork client with the given HTTP client, configured by
+// the environment variables.
+func NewClientWithHTTPClient(appName, apiKey string, client *http.Client) *Client {
+	return New(appName, apiKey, append(envOptions(), WithHTTPClient(client))...)
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.baseURL, "/")+"/", bytes.NewReader(b))
-	if err != nil {
-		return trace.SetCallStatus(ctx, err)
+// SendEvents sends the given events to Telefork, in chunks within the batch limits.
+// Failed requests are retried according to the retry policy. It returns the error of the first failed chunk.
+func (c *Client) SendEvents(ctx context.Context, events []interface{}) error {
+	for _, chunk := range c.SendChunks(ctx, events) {
+		if chunk.Err != nil {
+			return chunk.Err
+		}
 	}
 
-	resp, err := c.http.Do(req)
-	if err != nil {
-		return trace.SetCallStatus(ctx, err)
-	}
-	defer resp.Body.Close()
+	return nil
+}
 
-	if resp.StatusCode != http.StatusCreated {
-		return trace.SetCallStatus(ctx, fmt.Errorf("Unexpected status code: %d", resp.StatusCode))
+// sendWithRetries posts the body to Telefork, retrying according to the retry policy.
+func (c *Client) sendWithRetries(ctx context.Context, body []byte) error {
+	header := c.headers.Clone()
+	if c.compress {
+		var err error
+		if body, err = gzipBody(body); err != nil {
+			return errors.Wrap(err, "failed to compress request")
+		}
+		if header == nil {
+			header = make(http.Header)
+		}
+		header.Set("Content-Encoding", "gzip")
 	}
 
-	return trace.SetCallStatus(ctx, nil)
+	sender := httpsend.Sender{
+		Client: c.http,
+		URL:    strings.TrimSuffix(c.baseURL, "/") + "/",
+		Header: header,
+		Retry:  c.retry,
+		// Telefork responds with 201 to the events it accepted.
+		Accept: func(statusCode int) bool { return statusCode == http.StatusCreated },
+	}
+	return sender.Post(ctx, body)
 }
 
 // Transport is an http.RoundTripper that adds the X-OUTREACH-CLIENT-APP-ID and X-OUTREACH-CLIENT-LOGGING headers.
@@ -0,0 +1,25 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the compression of the request bodies.
+
+package telefork
+
+import (
+	"bytes"
+	"compress/gzip"
+)
+
+// gzipBody compresses the request body. The batches of events are highly repetitive JSON,
+// they usually compress to a fraction of their size.
+func gzipBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package telefork
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/getoutreach/devtel/internal/httpsend"
+	"github.com/getoutreach/devtel/internal/httpsend/httpsendtest"
+)
+
+// decompressingServer returns a server that decompresses the gzipped requests, and records their bodies.
+func decompressingServer(t *testing.T, bodies *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if !assert.NoError(t, err) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+
+		b, err := io.ReadAll(body)
+		assert.NoError(t, err)
+		*bodies = append(*bodies, r.Header.Get("Content-Encoding")+":"+string(b))
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+}
+
+func TestClientCompressesRequests(t *testing.T) {
+	var bodies []string
+	server := decompressingServer(t, &bodies)
+	defer server.Close()
+
+	opts := []Option{WithEndpoint(server.URL), WithHTTPClient(server.Client()), WithBatchLimits(httpsend.BatchLimits{MaxEvents: 2})}
+	client := New("testApp", "testKey", append(opts, WithCompression(true))...)
+	assert.NoError(t, client.SendEvents(context.Background(), httpsendtest.Batch(3)))
+
+	client = New("testApp", "testKey", opts...)
+	assert.NoError(t, client.SendEvents(context.Background(), httpsendtest.Batch(1)))
+
+	assert.Equal(t, []string{
+		`gzip:[{"hook":"before:deploy"},{"hook":"before:deploy"}]`,
+		`gzip:[{"hook":"before:deploy"}]`,
+		`:[{"hook":"before:deploy"}]`,
+	}, bodies)
+}
+
+func TestClientCompressionFromEnv(t *testing.T) {
+	var bodies []string
+	server := decompressingServer(t, &bodies)
+	defer server.Close()
+
+	os.Setenv("OUTREACH_TELEFORK_ENDPOINT", server.URL)
+	os.Setenv("OUTREACH_TELEFORK_GZIP", "true")
+	defer os.Unsetenv("OUTREACH_TELEFORK_GZIP")
+
+	client := NewClientWithHTTPClient("testApp", "testKey", server.Client())
+	assert.NoError(t, client.SendEvents(context.Background(), httpsendtest.Batch(1)))
+	assert.Equal(t, []string{`gzip:[{"hook":"before:deploy"}]`}, bodies)
+}
+
+func TestGzipBodyIsSmaller(t *testing.T) {
+	body := []byte("[" + `{"hook":"before:deploy","devenv":{"version":"v1.2.3"}}`)
+	for i := 0; i < 100; i++ {
+		body = append(body, `,{"hook":"before:deploy","devenv":{"version":"v1.2.3"}}`...)
+	}
+	body = append(body, ']')
+
+	compressed, err := gzipBody(body)
+	assert.NoError(t, err)
+	assert.Less(t, len(compressed)*10, len(body))
+}
@@ -0,0 +1,131 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the options of the Telefork client.
+
+package telefork
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/getoutreach/devtel/internal/httpsend"
+)
+
+// DefaultEndpoint is the Telefork endpoint used unless configured otherwise.
+const DefaultEndpoint = "https://telefork.outreach.io/"
+
+// DefaultTimeout is the timeout of each request, unless configured otherwise or set on the HTTP client.
+const DefaultTimeout = 10 * time.Second
+
+// DefaultSendTimeout bounds sending a batch of events, including the retries, unless configured otherwise
+// or the context has an earlier deadline.
+const DefaultSendTimeout = time.Minute
+
+// Option configures a Client.
+type Option func(*clientConfig)
+
+// clientConfig is the configuration the Client is built from.
+type clientConfig struct {
+	endpoint  string
+	http      *http.Client
+	timeout   time.Duration
+	send      time.Duration
+	transport http.RoundTripper
+	headers   http.Header
+	retry     httpsend.RetryPolicy
+	limits    httpsend.BatchLimits
+	compress  bool
+}
+
+// WithEndpoint sets the Telefork endpoint. Defaults to DefaultEndpoint.
+func WithEndpoint(endpoint string) Option {
+	return func(c *clientConfig) {
+		c.endpoint = endpoint
+	}
+}
+
+// WithHTTPClient sets the HTTP client the requests are made with. The client is copied, it's not modified.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *clientConfig) {
+		c.http = client
+	}
+}
+
+// WithTimeout sets the timeout of each request, including the reading of the response. Defaults to the timeout
+// of the HTTP client, or DefaultTimeout when it has none.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *clientConfig) {
+		c.timeout = timeout
+	}
+}
+
+// WithSendTimeout bounds sending a batch of events, including the retries. The deadline of the context applies
+// when it's earlier. Defaults to DefaultSendTimeout, negative value disables it.
+func WithSendTimeout(timeout time.Duration) Option {
+	return func(c *clientConfig) {
+		c.send = timeout
+	}
+}
+
+// WithTransport sets the transport the requests are made with. Defaults to the transport of the HTTP client.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *clientConfig) {
+		c.transport = rt
+	}
+}
+
+// WithUserAgent sets the User-Agent header of the requests.
+func WithUserAgent(userAgent string) Option {
+	return WithHeader("User-Agent", userAgent)
+}
+
+// WithHeader adds a header to the requests. It can't override the headers the Transport sets.
+func WithHeader(key, value string) Option {
+	return func(c *clientConfig) {
+		c.headers.Add(key, value)
+	}
+}
+
+// WithRetryPolicy sets the retry policy. Defaults to httpsend.DefaultRetryPolicy.
+func WithRetryPolicy(p httpsend.RetryPolicy) Option {
+	return func(c *clientConfig) {
+		c.retry = p
+	}
+}
+
+// WithBatchLimits sets the batch limits. Defaults to httpsend.DefaultBatchLimits.
+func WithBatchLimits(l httpsend.BatchLimits) Option {
+	return func(c *clientConfig) {
+		c.limits = l
+	}
+}
+
+// WithCompression enables or disables gzip compression of the request bodies.
+func WithCompression(enabled bool) Option {
+	return func(c *clientConfig) {
+		c.compress = enabled
+	}
+}
+
+// envOptions returns the options set by the environment variables: OUTREACH_TELEFORK_ENDPOINT sets the endpoint,
+// OUTREACH_TELEFORK_GZIP enables compression, and OUTREACH_TELEFORK_TIMEOUT sets the timeout of each request.
+func envOptions() []Option {
+	var opts []Option
+	if endpoint := os.Getenv("OUTREACH_TELEFORK_ENDPOINT"); endpoint != "" {
+		opts = append(opts, WithEndpoint(endpoint))
+	}
+
+	// Invalid values leave the default timeout.
+	if timeout, err := time.ParseDuration(os.Getenv("OUTREACH_TELEFORK_TIMEOUT")); err == nil && timeout > 0 {
+		opts = append(opts, WithTimeout(timeout))
+	}
+
+	// Invalid values leave the compression disabled.
+	if compress, err := strconv.ParseBool(os.Getenv("OUTREACH_TELEFORK_GZIP")); err == nil {
+		opts = append(opts, WithCompression(compress))
+	}
+
+	return opts
+}
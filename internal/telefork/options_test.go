@@ -0,0 +1,96 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package telefork
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/getoutreach/devtel/internal/httpsend"
+	"github.com/getoutreach/devtel/internal/httpsend/httpsendtest"
+)
+
+// countingTransport counts the requests that pass through it.
+type countingTransport struct {
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestNewAppliesOptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "devtel/1.0", r.Header.Get("User-Agent"))
+		assert.Equal(t, []string{"a", "b"}, r.Header.Values("X-Team"))
+		assert.Equal(t, "testApp", r.Header.Get("X-OUTREACH-CLIENT-APP-ID"))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	transport := &countingTransport{}
+	httpClient := &http.Client{}
+	client := New("testApp", "testKey",
+		WithEndpoint(server.URL),
+		WithHTTPClient(httpClient),
+		WithTransport(transport),
+		WithTimeout(time.Second),
+		WithUserAgent("devtel/1.0"),
+		WithHeader("X-Team", "a"),
+		WithHeader("X-Team", "b"),
+		WithRetryPolicy(httpsend.RetryPolicy{MaxAttempts: 1}),
+	)
+
+	assert.NoError(t, client.SendEvents(context.Background(), httpsendtest.Batch(1)))
+	assert.Equal(t, 1, transport.requests)
+	assert.Equal(t, time.Second, client.http.Timeout)
+	assert.Equal(t, 1, client.retry.MaxAttempts)
+
+	// The client passed in is not modified.
+	assert.Nil(t, httpClient.Transport)
+	assert.Zero(t, httpClient.Timeout)
+}
+
+func TestClientsAreIndependent(t *testing.T) {
+	httpClient := &http.Client{}
+	first := New("first", "key", WithHTTPClient(httpClient), WithEndpoint("http://first"))
+	second := New("second", "key", WithHTTPClient(httpClient))
+
+	assert.Equal(t, "http://first", first.baseURL)
+	assert.Equal(t, DefaultEndpoint, second.baseURL)
+	assert.Equal(t, "first", first.http.Transport.(*Transport).appName)
+	assert.Equal(t, "second", second.http.Transport.(*Transport).appName)
+	assert.Nil(t, httpClient.Transport)
+}
+
+func TestNewDefaultsTimeouts(t *testing.T) {
+	assert.Equal(t, DefaultTimeout, New("testApp", "testKey").http.Timeout)
+	assert.Equal(t, DefaultSendTimeout, New("testApp", "testKey").sendTimeout)
+
+	// The timeout of the client passed in is kept.
+	client := New("testApp", "testKey", WithHTTPClient(&http.Client{Timeout: time.Second}))
+	assert.Equal(t, time.Second, client.http.Timeout)
+}
+
+func TestSendTimeoutBoundsRetries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := New("testApp", "testKey",
+		WithEndpoint(server.URL),
+		WithSendTimeout(100*time.Millisecond),
+		WithRetryPolicy(httpsend.RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Second, MaxBackoff: time.Second}),
+	)
+
+	start := time.Now()
+	assert.Error(t, client.SendEvents(context.Background(), httpsendtest.Batch(1)))
+	assert.Less(t, time.Since(start), time.Second)
+}
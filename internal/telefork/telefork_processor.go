@@ -4,7 +4,11 @@
 
 package telefork
 
-import "context"
+import (
+	"context"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+)
 
 // Processor wraps the Telefork Client for use in a Tracker.
 type Processor struct {
@@ -18,7 +22,41 @@ func NewProcessor(appName, apiKey string) *Processor {
 	}
 }
 
+// NewProcessorWithClient returns a new Telefork Processor that sends the events with the given client.
+func NewProcessorWithClient(client *Client) *Processor {
+	return &Processor{
+		client: client,
+	}
+}
+
 // ProcessRecords sends the given events to Telefork.
 func (p *Processor) ProcessRecords(ctx context.Context, events []interface{}) error {
 	return p.client.SendEvents(ctx, events)
 }
+
+// ProcessBatch sends the given events to Telefork in chunks, and returns the outcome of each event.
+// The events Telefork refuses, once their chunks are bisected, and the events that can't be marshaled, are rejected,
+// so that they're not sent again.
+func (p *Processor) ProcessBatch(ctx context.Context, events []interface{}) (devspace.Result, error) {
+	result := devspace.NewResult(len(events), devspace.Retryable)
+
+	var err error
+	for _, chunk := range p.client.SendChunks(ctx, events) {
+		outcome := devspace.Accepted
+		switch {
+		case chunk.Rejected:
+			outcome = devspace.Rejected
+		case chunk.Err != nil:
+			outcome = devspace.Retryable
+			if err == nil {
+				err = chunk.Err
+			}
+		}
+
+		for i := chunk.Start; i < chunk.End; i++ {
+			result[i] = outcome
+		}
+	}
+
+	return result, err
+}
@@ -4,6 +4,7 @@ package telefork
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -11,6 +12,9 @@ import (
 	"testing"
 
 	"github.com/getoutreach/devtel/internal/devspace"
+	"github.com/getoutreach/devtel/internal/httpsend"
+	"github.com/getoutreach/devtel/internal/httpsend/httpsendtest"
+	"github.com/getoutreach/devtel/internal/store"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -41,3 +45,87 @@ func TestTeleforkProcessor(t *testing.T) {
 		devspace.Event{Hook: "before:deploy", Timestamp: 2147483605},
 	})
 }
+
+func TestTeleforkProcessorReportsChunkOutcomes(t *testing.T) {
+	// Telefork refuses the requests over 3 events, and the invalid event, and fails with the unavailable one.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var events []map[string]string
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&events))
+
+		status := http.StatusCreated
+		for _, e := range events {
+			switch e["hook"] {
+			case "invalid":
+				status = http.StatusUnprocessableEntity
+			case "unavailable":
+				status = http.StatusInternalServerError
+			}
+		}
+		if len(events) > 3 {
+			status = http.StatusRequestEntityTooLarge
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	client := &Client{http: server.Client(), baseURL: server.URL, retry: httpsend.RetryPolicy{}, limits: httpsend.BatchLimits{MaxEvents: 4}}
+	tp := &Processor{client: client}
+
+	events := httpsendtest.Events("before:build", "invalid", "after:build", "before:deploy", "after:deploy", "unavailable")
+	result, err := tp.ProcessBatch(context.Background(), events)
+	assert.Error(t, err)
+	assert.Equal(t, devspace.Result{
+		devspace.Accepted, devspace.Rejected, devspace.Accepted, devspace.Accepted,
+		devspace.Retryable, devspace.Retryable,
+	}, result)
+}
+
+func TestTeleforkProcessorRejectsUnmarshalableEvents(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client := &Client{http: server.Client(), baseURL: server.URL, retry: httpsend.RetryPolicy{}, limits: httpsend.BatchLimits{}}
+	tp := &Processor{client: client}
+
+	events := httpsendtest.Batch(3)
+	events[1] = func() {}
+	result, err := tp.ProcessBatch(context.Background(), events)
+	assert.NoError(t, err)
+	assert.Equal(t, devspace.Result{devspace.Accepted, devspace.Rejected, devspace.Accepted}, result)
+	assert.Equal(t, []string{`[{"hook":"before:deploy"}]`, `[{"hook":"before:deploy"}]`}, bodies)
+}
+
+func TestFlushMarksOnlyDeliveredChunks(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := &Client{http: server.Client(), baseURL: server.URL, retry: httpsend.RetryPolicy{}, limits: httpsend.BatchLimits{MaxEvents: 2}}
+	s := store.NewMemory()
+	tracker := devspace.NewTracker(&Processor{client: client}, s)
+	for _, id := range []string{"1", "2", "3"} {
+		tracker.Track(context.Background(), &devspace.Event{Hook: "before:deploy", ExecutionID: id})
+	}
+
+	assert.Error(t, tracker.Flush(context.Background()))
+
+	var e devspace.Event
+	c := s.GetUnprocessed(context.Background())
+	assert.Equal(t, 1, c.Len())
+	assert.True(t, c.Next())
+	assert.NoError(t, c.Value(&e))
+	assert.Equal(t, "3", e.ExecutionID)
+}
@@ -0,0 +1,78 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the rendering of the request bodies.
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// ParseTemplate parses the template of the request bodies. With a request per event, the template is executed
+// with the fields of the event, e.g. {{ .hook }}. Otherwise it's executed with the list of the events of the request,
+// e.g. {{ range . }}{{ .hook }}{{ end }}. The json function encodes a value as JSON, e.g. {{ json .command }}.
+func ParseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse webhook template")
+	}
+
+	return tmpl, nil
+}
+
+// request is the body of a request, and the indexes of its events in the decoded events, end is exclusive.
+// The err is set when the body couldn't be rendered.
+type request struct {
+	start int
+	end   int
+	body  []byte
+	err   error
+}
+
+// requests renders the bodies of the requests the decoded events are sent in.
+func (p *Processor) requests(data []interface{}) []request {
+	if p.perEvent {
+		requests := make([]request, 0, len(data))
+		for i, d := range data {
+			body, err := p.render(d)
+			requests = append(requests, request{start: i, end: i + 1, body: body, err: err})
+		}
+		return requests
+	}
+
+	// The batches are split by the size of their JSON, which is what they're sent as without a template.
+	chunks := p.limits.Split(data)
+
+	requests := make([]request, 0, len(chunks))
+	for _, c := range chunks {
+		if c.Err != nil {
+			requests = append(requests, request{start: c.Start, end: c.End, err: c.Err})
+			continue
+		}
+		body, err := p.render(data[c.Start:c.End])
+		requests = append(requests, request{start: c.Start, end: c.End, body: body, err: err})
+	}
+	return requests
+}
+
+// render renders the body of a request, the JSON of the data without a template.
+func (p *Processor) render(data interface{}) ([]byte, error) {
+	if p.tmpl == nil {
+		return json.Marshal(data)
+	}
+
+	var buf bytes.Buffer
+	if err := p.tmpl.Execute(&buf, data); err != nil {
+		return nil, errors.Wrap(err, "failed to render webhook template")
+	}
+	return buf.Bytes(), nil
+}
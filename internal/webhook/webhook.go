@@ -0,0 +1,239 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+// Description: This file contains the package documentation and the Processor posting the events.
+
+// Package webhook contains the processor posting the events to an arbitrary HTTP endpoint, for the systems outside
+// of the Telefork pipeline. The request body is the JSON of the events, or is rendered from a Go template.
+package webhook
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"strconv"
+	"text/template"
+
+	"github.com/getoutreach/gobox/pkg/log"
+	"github.com/getoutreach/gobox/pkg/trace"
+	"github.com/pkg/errors"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+	"github.com/getoutreach/devtel/internal/httpsend"
+)
+
+// Option configures a Processor.
+type Option func(*Processor)
+
+// WithHeader adds a header to the requests.
+func WithHeader(key, value string) Option {
+	return func(p *Processor) {
+		p.sender.Header.Add(key, value)
+	}
+}
+
+// WithBearerToken authenticates the requests with the bearer token.
+func WithBearerToken(token string) Option {
+	return func(p *Processor) {
+		p.sender.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// WithBasicAuth authenticates the requests with the username and password.
+func WithBasicAuth(username, password string) Option {
+	return func(p *Processor) {
+		p.sender.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+	}
+}
+
+// WithTemplate sets the template the request bodies are rendered from, see ParseTemplate.
+// Defaults to the JSON of the events.
+func WithTemplate(tmpl *template.Template) Option {
+	return func(p *Processor) {
+		p.tmpl = tmpl
+	}
+}
+
+// WithPerEvent sends a request per event, instead of a request per batch of events.
+func WithPerEvent(perEvent bool) Option {
+	return func(p *Processor) {
+		p.perEvent = perEvent
+	}
+}
+
+// WithHTTPClient sets the HTTP client the requests are made with. The client gets httpsend.DefaultTimeout
+// when it has no timeout.
+func WithHTTPClient(client *http.Client) Option {
+	return func(p *Processor) {
+		p.sender.Client = client
+	}
+}
+
+// WithRetryPolicy sets the retry policy. Defaults to httpsend.DefaultRetryPolicy.
+func WithRetryPolicy(policy httpsend.RetryPolicy) Option {
+	return func(p *Processor) {
+		p.sender.Retry = policy
+	}
+}
+
+// WithBatchLimits sets the batch limits. Defaults to httpsend.DefaultBatchLimits.
+func WithBatchLimits(limits httpsend.BatchLimits) Option {
+	return func(p *Processor) {
+		p.limits = limits
+	}
+}
+
+// envOptions returns the options set by the environment variables: DEVTEL_WEBHOOK_HEADERS with comma separated
+// key=value pairs, DEVTEL_WEBHOOK_BEARER_TOKEN, DEVTEL_WEBHOOK_USERNAME and DEVTEL_WEBHOOK_PASSWORD,
+// DEVTEL_WEBHOOK_TEMPLATE or DEVTEL_WEBHOOK_TEMPLATE_FILE, and DEVTEL_WEBHOOK_PER_EVENT.
+func envOptions() ([]Option, error) {
+	var opts []Option
+
+	for k, values := range httpsend.ParseHeaders(os.Getenv("DEVTEL_WEBHOOK_HEADERS")) {
+		for _, v := range values {
+			opts = append(opts, WithHeader(k, v))
+		}
+	}
+
+	if token := os.Getenv("DEVTEL_WEBHOOK_BEARER_TOKEN"); token != "" {
+		opts = append(opts, WithBearerToken(token))
+	}
+	if username := os.Getenv("DEVTEL_WEBHOOK_USERNAME"); username != "" {
+		opts = append(opts, WithBasicAuth(username, os.Getenv("DEVTEL_WEBHOOK_PASSWORD")))
+	}
+
+	text := os.Getenv("DEVTEL_WEBHOOK_TEMPLATE")
+	if file := os.Getenv("DEVTEL_WEBHOOK_TEMPLATE_FILE"); file != "" && text == "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read webhook template")
+		}
+		text = string(b)
+	}
+	if text != "" {
+		tmpl, err := ParseTemplate(text)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithTemplate(tmpl))
+	}
+
+	// Invalid values leave a request per batch.
+	if perEvent, err := strconv.ParseBool(os.Getenv("DEVTEL_WEBHOOK_PER_EVENT")); err == nil {
+		opts = append(opts, WithPerEvent(perEvent))
+	}
+
+	return opts, nil
+}
+
+// Processor posts the events to a URL. The events are sent in batches within the batch limits, or one by one,
+// and the failed requests are retried, the same way the Telefork client does it. Any 2xx response is a success.
+type Processor struct {
+	sender   httpsend.Sender
+	tmpl     *template.Template
+	perEvent bool
+	limits   httpsend.BatchLimits
+}
+
+// New returns a new Processor posting the events to the URL, configured by the options.
+func New(url string, opts ...Option) *Processor {
+	p := &Processor{
+		sender: httpsend.Sender{
+			Client: http.DefaultClient,
+			URL:    url,
+			Header: make(http.Header),
+			Retry:  httpsend.DefaultRetryPolicy(),
+		},
+		limits: httpsend.DefaultBatchLimits(),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.sender.Client = httpsend.WithDefaultTimeout(p.sender.Client)
+	if p.sender.Header.Get("Content-Type") == "" {
+		p.sender.Header.Set("Content-Type", "application/json")
+	}
+
+	return p
+}
+
+// NewProcessor returns a new Processor posting the events to DEVTEL_WEBHOOK_URL, configured by the environment
+// variables.
+func NewProcessor() (*Processor, error) {
+	url := os.Getenv("DEVTEL_WEBHOOK_URL")
+	if url == "" {
+		return nil, errors.New("DEVTEL_WEBHOOK_URL is not set")
+	}
+
+	opts, err := envOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	return New(url, opts...), nil
+}
+
+// ProcessRecords posts the given events.
+func (p *Processor) ProcessRecords(ctx context.Context, events []interface{}) error {
+	_, err := p.ProcessBatch(ctx, events)
+	return err
+}
+
+// ProcessBatch posts the given events, and returns the outcome of each event. The events that can't be encoded
+// or rendered, and the events the server refused, are rejected, they would fail the same way on the next flush.
+// Once a request fails after the retries, the rest is not sent, and is retryable.
+func (p *Processor) ProcessBatch(ctx context.Context, events []interface{}) (devspace.Result, error) {
+	ctx = trace.StartCall(ctx, "webhook.ProcessBatch")
+	defer trace.EndCall(ctx)
+
+	result := devspace.NewResult(len(events), devspace.Rejected)
+
+	// The template gets the decoded events, the indexes map them back to the batch.
+	var data []interface{}
+	var indexes []int
+	for i, e := range events {
+		d, err := devspace.Fields(e)
+		if err != nil {
+			continue
+		}
+		data = append(data, d)
+		indexes = append(indexes, i)
+	}
+
+	var err, stopped error
+	var requests int
+	for _, r := range p.requests(data) {
+		outcome := devspace.Accepted
+		switch {
+		case stopped != nil:
+			outcome = devspace.Retryable
+		case r.err != nil:
+			// The error is traced with the call status.
+			outcome = devspace.Rejected
+			if err == nil {
+				err = r.err
+			}
+		default:
+			requests++
+			if serr := p.sender.Post(ctx, r.body); serr != nil {
+				var se *httpsend.StatusError
+				if errors.As(serr, &se) && se.Rejected() {
+					outcome = devspace.Rejected
+				} else {
+					outcome = devspace.Retryable
+					stopped = serr
+				}
+				if err == nil {
+					err = serr
+				}
+			}
+		}
+
+		for i := r.start; i < r.end; i++ {
+			result[indexes[i]] = outcome
+		}
+	}
+	trace.AddInfo(ctx, log.F{"webhook.requests": requests})
+
+	return result, trace.SetCallStatus(ctx, err)
+}
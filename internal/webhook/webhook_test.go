@@ -0,0 +1,159 @@
+// Copyright 2022 Outreach Corporation. All Rights Reserved.
+
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/getoutreach/devtel/internal/devspace"
+	"github.com/getoutreach/devtel/internal/httpsend"
+	"github.com/getoutreach/devtel/internal/httpsend/httpsendtest"
+)
+
+// fakeServer records the requests made to it, and responds with the status codes in order, and 200 afterwards.
+type fakeServer struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+	headers  []http.Header
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := http.StatusOK
+	if n := len(s.bodies); n < len(s.statuses) {
+		status = s.statuses[n]
+	}
+	s.bodies = append(s.bodies, string(b))
+	s.headers = append(s.headers, r.Header)
+
+	w.WriteHeader(status)
+}
+
+func TestProcessorPostsBatches(t *testing.T) {
+	fs := &fakeServer{}
+	server := httptest.NewServer(fs)
+	defer server.Close()
+
+	p := New(server.URL, WithBearerToken("secret"), WithHeader("X-Team", "devenv"),
+		WithBatchLimits(httpsend.BatchLimits{MaxEvents: 2}))
+
+	events := append(httpsendtest.Events("before:build", "after:build", "before:deploy"), func() {})
+	result, err := p.ProcessBatch(context.Background(), events)
+	assert.NoError(t, err)
+	assert.Equal(t, devspace.Result{devspace.Accepted, devspace.Accepted, devspace.Accepted, devspace.Rejected}, result)
+
+	assert.Equal(t, []string{
+		`[{"hook":"before:build"},{"hook":"after:build"}]`,
+		`[{"hook":"before:deploy"}]`,
+	}, fs.bodies)
+	assert.Equal(t, "Bearer secret", fs.headers[0].Get("Authorization"))
+	assert.Equal(t, "devenv", fs.headers[0].Get("X-Team"))
+	assert.Equal(t, "application/json", fs.headers[0].Get("Content-Type"))
+}
+
+func TestProcessorRendersTemplate(t *testing.T) {
+	fs := &fakeServer{}
+	server := httptest.NewServer(fs)
+	defer server.Close()
+
+	tmpl, err := ParseTemplate(`{"text": "{{ range $i, $e := . }}{{ if $i }}, {{ end }}{{ $e.hook }}{{ end }}"}`)
+	assert.NoError(t, err)
+	p := New(server.URL, WithTemplate(tmpl))
+	assert.NoError(t, p.ProcessRecords(context.Background(), httpsendtest.Events("before:build", "after:build")))
+
+	tmpl, err = ParseTemplate(`{{ .hook }} {{ json .hook }}`)
+	assert.NoError(t, err)
+	p = New(server.URL, WithTemplate(tmpl), WithPerEvent(true), WithBasicAuth("yoda", "force"),
+		WithHeader("Content-Type", "text/plain"))
+	assert.NoError(t, p.ProcessRecords(context.Background(), httpsendtest.Events("before:deploy", "after:deploy")))
+
+	assert.Equal(t, []string{
+		`{"text": "before:build, after:build"}`,
+		`before:deploy "before:deploy"`,
+		`after:deploy "after:deploy"`,
+	}, fs.bodies)
+	assert.Equal(t, "text/plain", fs.headers[1].Get("Content-Type"))
+	username, password, ok := (&http.Request{Header: fs.headers[1]}).BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "yoda", username)
+	assert.Equal(t, "force", password)
+}
+
+func TestProcessorRetriesFailedRequests(t *testing.T) {
+	// The first event is retried and delivered, the second is refused, the third fails after the retries
+	// and the fourth isn't sent.
+	fs := &fakeServer{statuses: []int{
+		http.StatusBadGateway, http.StatusOK,
+		http.StatusBadRequest,
+		http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable,
+	}}
+	server := httptest.NewServer(fs)
+	defer server.Close()
+
+	p := New(server.URL, WithPerEvent(true), WithRetryPolicy(httpsendtest.RetryPolicy()))
+	result, err := p.ProcessBatch(context.Background(), httpsendtest.Events("before:build", "after:build", "before:deploy", "after:deploy"))
+	assert.Error(t, err)
+	assert.Equal(t, devspace.Result{devspace.Accepted, devspace.Rejected, devspace.Retryable, devspace.Retryable}, result)
+	assert.Len(t, fs.bodies, 6)
+}
+
+func TestProcessorRejectsUnrenderableEvents(t *testing.T) {
+	fs := &fakeServer{}
+	server := httptest.NewServer(fs)
+	defer server.Close()
+
+	tmpl, err := ParseTemplate(`{{ .command.name }}`)
+	assert.NoError(t, err)
+	p := New(server.URL, WithTemplate(tmpl), WithPerEvent(true))
+
+	events := []interface{}{
+		map[string]interface{}{"hook": "before:build", "command": map[string]string{"name": "build"}},
+		map[string]interface{}{"hook": "after:build", "command": "build"},
+	}
+	result, err := p.ProcessBatch(context.Background(), events)
+	assert.Error(t, err)
+	assert.Equal(t, devspace.Result{devspace.Accepted, devspace.Rejected}, result)
+	assert.Equal(t, []string{"build"}, fs.bodies)
+}
+
+func TestNewProcessorFromEnv(t *testing.T) {
+	fs := &fakeServer{}
+	server := httptest.NewServer(fs)
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "body.tmpl")
+	assert.NoError(t, os.WriteFile(file, []byte(`{{ len . }} events`), 0o600))
+
+	t.Setenv("DEVTEL_WEBHOOK_URL", server.URL)
+	t.Setenv("DEVTEL_WEBHOOK_HEADERS", "X-Team=devenv, malformed")
+	t.Setenv("DEVTEL_WEBHOOK_TEMPLATE_FILE", file)
+
+	p, err := NewProcessor()
+	assert.NoError(t, err)
+	assert.NoError(t, p.ProcessRecords(context.Background(), httpsendtest.Events("before:build", "after:build")))
+	assert.Equal(t, []string{"2 events"}, fs.bodies)
+	assert.Equal(t, "devenv", fs.headers[0].Get("X-Team"))
+	assert.Equal(t, httpsend.DefaultTimeout, p.sender.Client.Timeout)
+
+	t.Setenv("DEVTEL_WEBHOOK_TEMPLATE", "{{ .hook")
+	_, err = NewProcessor()
+	assert.Error(t, err)
+}